@@ -58,6 +58,6 @@ func main() {
 		os.Exit(-1)
 	}
 
-	app.Build(ProductName)
+	app.Build(ProductName, Version)
 	app.Start()
 }
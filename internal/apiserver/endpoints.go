@@ -1,7 +1,11 @@
 package apiserver
 
 import (
+	"encoding/json"
+	"errors"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"zipreport-server/pkg/monitor"
 	"zipreport-server/pkg/render"
 
@@ -15,40 +19,104 @@ import (
 // usage from oversized or malicious uploads.
 const MaxUploadBytes = 128 << 20 // 128 MiB
 
-func renderAction(g *gin.Context, e *render.Engine, m *monitor.Metrics) {
+// error codes; render job errors use the codes defined in the render package
+const ErrCodeInvalidRequest = "invalid_request"
+const ErrCodePayloadTooLarge = "payload_too_large"
+const ErrCodeNotFound = "not_found"
+const ErrCodeNotFinished = "job_not_finished"
+
+// ctxRequestId is the gin context key of the request id
+const ctxRequestId = "zptRequestId"
+
+// StatusClientClosedRequest is the non-standard status of requests canceled by the client
+const StatusClientClosedRequest = 499
+
+func renderAction(g *gin.Context, e *render.Engine, m *monitor.Metrics, svc *Services) {
 	// cap request body size
 	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, MaxUploadBytes)
 
-	// validate requestId
-	// Note: reqId may be supplied as an external header, make sure it is not abused
-	reqId, err := uuid.Parse(g.GetHeader(httplog.HeaderRequestID))
+	reqId := requestId(g)
+	logger := log.FromContext(g)
+
+	// take a place in the render queue before reading the upload; form fields, including async, are only
+	// available once the multipart body is parsed
+	ticket, err := e.Queue.Reserve(clientName(g))
 	if err != nil {
-		reqId, _ = uuid.NewRandom()
+		m.TotalOps.Inc()
+		logger.Warn("render job rejected", log.KV{"reqId": reqId, "error": err.Error()})
+		errQueue(g, err)
+		return
+	}
+	defer ticket.Release()
+
+	// asynchronous render, with optional result callback; the job takes its own place in the queue
+	if optionalBoolValue(g, ParamAsync, false) {
+		ticket.Release()
+		if svc.Jobs == nil {
+			errBadRequest(g, "asynchronous jobs are not enabled")
+			return
+		}
+		submitJob(g, svc)
+		return
+	}
+	if len(g.PostForm(ParamCallbackURL)) > 0 {
+		errBadRequest(g, "callback_url requires async=true")
+		return
 	}
-	logger := log.FromContext(g)
 
 	m.TotalOps.Inc() // update metrics
+
 	job, err := buildRenderJob(g, reqId)
 	if err != nil {
 		logger.Error(err, "error building render job", log.KV{"reqId": reqId})
-		errBadRequest(g, "error building render job")
+		errBuildJob(g, err)
 		return
 	}
+	job.Ticket = ticket
+	runRenderJob(g, e, m, job)
+}
 
+// requestId returns the request id from the request header, or a new id
+// Note: reqId may be supplied as an external header, make sure it is not abused
+// The id is stored in the request context, so error responses report the id of the job
+func requestId(g *gin.Context) uuid.UUID {
+	if v, ok := g.Get(ctxRequestId); ok {
+		return v.(uuid.UUID)
+	}
+	reqId, err := uuid.Parse(g.GetHeader(httplog.HeaderRequestID))
+	if err != nil {
+		reqId, _ = uuid.NewRandom()
+	}
+	g.Set(ctxRequestId, reqId)
+	return reqId
+}
+
+// runRenderJob renders the job and writes the output to the response
+func runRenderJob(g *gin.Context, e *render.Engine, m *monitor.Metrics, job *render.Job) {
+	var err error
+	reqId := job.Id
+	logger := log.FromContext(g)
+
+	// cancel the job if the client disconnects
+	job.Context = g.Request.Context()
 	result := e.RenderJob(job)
+	if errors.Is(result.Error, render.ErrJobCanceled) {
+		m.CanceledOps.Inc() // update metrics
+		logger.Warn("render job canceled by the client", log.KV{"reqId": reqId})
+		errRenderFailed(g, result)
+		return
+	}
 	if !result.Success {
 		m.FailedOps.Inc() // update metrics
 		logger.Error(result.Error, "error generating pdf", log.KV{"reqId": reqId})
-		errServerError(g)
+		errRenderFailed(g, result)
 		return
 	}
 	m.ConversionTime.Observe(result.ElapsedTime)
 
-	// write pdf to output
-	g.Writer.Header().Set("Content-Type", "application/pdf")
-	_, err = g.Writer.Write(result.Output)
-	if err != nil {
-		logger.Error(err, "error writing pdf to api response", log.KV{"reqId": reqId})
+	// write output
+	if err = writeOutput(g, job, result); err != nil {
+		logger.Error(err, "error writing output to api response", log.KV{"reqId": reqId})
 		m.FailedOps.Inc()
 		g.Render(http.StatusInternalServerError, nil)
 		return
@@ -56,3 +124,45 @@ func renderAction(g *gin.Context, e *render.Engine, m *monitor.Metrics) {
 		m.SuccessOps.Inc()
 	}
 }
+
+// writeOutput writes the job output, or the output and its diagnostics, if collected
+func writeOutput(g *gin.Context, job *render.Job, result *render.JobResult) error {
+	if result.Diagnostics != nil {
+		return writeMultipart(g, job, result)
+	}
+	g.Writer.Header().Set("Content-Type", job.ContentType())
+	_, err := g.Writer.Write(result.Output)
+	return err
+}
+
+// writeMultipart writes the job output and its diagnostics as a multipart/form-data response,
+// with the "output" and "diagnostics" parts
+func writeMultipart(g *gin.Context, job *render.Job, result *render.JobResult) error {
+	diag, err := json.Marshal(result.Diagnostics)
+	if err != nil {
+		return err
+	}
+	mw := multipart.NewWriter(g.Writer)
+	g.Writer.Header().Set("Content-Type", mw.FormDataContentType())
+	parts := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"output", job.ContentType(), result.Output},
+		{"diagnostics", "application/json", diag},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {p.contentType},
+			"Content-Disposition": {`form-data; name="` + p.name + `"`},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(p.data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
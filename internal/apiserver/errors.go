@@ -1,15 +1,130 @@
 package apiserver
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/zpt"
 
 	"github.com/gin-gonic/gin"
 )
 
+// errorStatus maps error codes to their http status; template errors are 422, and unknown codes are 500
+var errorStatus = map[string]int{
+	ErrCodeInvalidRequest:         http.StatusBadRequest,
+	ErrCodePayloadTooLarge:        http.StatusRequestEntityTooLarge,
+	ErrCodeNotFound:               http.StatusNotFound,
+	ErrCodeNotFinished:            http.StatusConflict,
+	render.CodeInvalidArchive:     http.StatusBadRequest,
+	render.CodeIndexNotFound:      http.StatusBadRequest,
+	render.CodeNavigationFailed:   http.StatusBadGateway,
+	render.CodeReadyTimeout:       http.StatusGatewayTimeout,
+	render.CodeJobTimeout:         http.StatusGatewayTimeout,
+	render.CodeBrowserUnavailable: http.StatusServiceUnavailable,
+	render.CodePdfFailed:          http.StatusInternalServerError,
+	render.CodeOutputFailed:       http.StatusInternalServerError,
+	render.CodeTooManyPages:       http.StatusUnprocessableEntity,
+	render.CodeQueueFull:          http.StatusTooManyRequests,
+	render.CodeQueueTimeout:       http.StatusTooManyRequests,
+	render.CodeCanceled:           StatusClientClosedRequest,
+	render.CodeShuttingDown:       http.StatusServiceUnavailable,
+	render.CodeInternal:           http.StatusInternalServerError,
+}
+
+// errorBody builds the JSON body of error responses: the error code and message, the request id, and whether
+// the request may succeed if retried; "error" holds the message, for compatibility with older clients
+func errorBody(c *gin.Context, code string, message string) gin.H {
+	return gin.H{
+		"error":     message,
+		"message":   message,
+		"code":      code,
+		"requestId": requestId(c).String(),
+		"retryable": render.Retryable(code),
+	}
+}
+
+// errResponse writes an error response, with the status of the error code
+func errResponse(c *gin.Context, code string, message string) {
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	c.AbortWithStatusJSON(status, errorBody(c, code, message))
+}
+
 func errBadRequest(c *gin.Context, errorMessage string) {
-	c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+	errResponse(c, ErrCodeInvalidRequest, errorMessage)
 }
 
 func errServerError(c *gin.Context) {
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected server error"})
+	errResponse(c, render.CodeInternal, render.MessageInternal)
+}
+
+func errNotFound(c *gin.Context, err error) {
+	errResponse(c, ErrCodeNotFound, err.Error())
+}
+
+// errBuildJob reports an invalid render request; archive errors are reported with their own code, and a missing
+// index file lists the HTML files in the report
+func errBuildJob(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var indexErr *zpt.IndexError
+	switch {
+	case errors.As(err, &indexErr):
+		body := errorBody(c, render.CodeIndexNotFound, indexErr.Error())
+		body["htmlFiles"] = indexErr.HtmlFiles
+		c.AbortWithStatusJSON(http.StatusBadRequest, body)
+	case errors.As(err, &maxBytesErr):
+		errResponse(c, ErrCodePayloadTooLarge, "request body too large")
+	case errors.Is(err, http.ErrMissingFile):
+		errBadRequest(c, "missing report file")
+	case errors.Is(err, zpt.ErrInvalidArchive), errors.Is(err, zpt.ErrIndexNotFound):
+		errResponse(c, render.ErrorCode(err), render.ErrorMessage(err))
+	default:
+		errBadRequest(c, "error building render job")
+	}
+}
+
+// errRenderFailed reports a failed render job, with the error code and its http status; template errors carry
+// the code signalled by the template, and job diagnostics are included, if collected
+func errRenderFailed(c *gin.Context, result *render.JobResult) {
+	code, message := render.ErrorCode(result.Error), render.ErrorMessage(result.Error)
+	status, ok := errorStatus[code]
+	var tplErr *render.TemplateError
+	var queueErr *render.QueueError
+	switch {
+	case errors.As(result.Error, &tplErr):
+		status = http.StatusUnprocessableEntity
+	case errors.As(result.Error, &queueErr):
+		setRetryAfter(c, queueErr)
+	case !ok:
+		status = http.StatusInternalServerError
+	}
+	body := errorBody(c, code, message)
+	if result.Diagnostics != nil {
+		body["diagnostics"] = result.Diagnostics
+	}
+	c.JSON(status, body)
+}
+
+// errShuttingDown reports a job rejected because the server is draining
+func errShuttingDown(c *gin.Context) {
+	errResponse(c, render.CodeShuttingDown, render.ErrShuttingDown.Error())
+}
+
+// errQueue reports a job rejected by the render queue; other errors are reported as server errors
+func errQueue(c *gin.Context, err error) {
+	var queueErr *render.QueueError
+	if !errors.As(err, &queueErr) {
+		errServerError(c)
+		return
+	}
+	setRetryAfter(c, queueErr)
+	errResponse(c, render.ErrorCode(err), err.Error())
+}
+
+// setRetryAfter sets the Retry-After header of a queue error response
+func setRetryAfter(c *gin.Context, err *render.QueueError) {
+	c.Header("Retry-After", strconv.Itoa(err.RetryAfter))
 }
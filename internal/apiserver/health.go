@@ -0,0 +1,23 @@
+package apiserver
+
+import (
+	"net/http"
+	"zipreport-server/pkg/render"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthzAction reports that the process is alive
+func healthzAction(g *gin.Context) {
+	g.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyzAction reports whether the server can accept render jobs, with the detail of each check
+func readyzAction(g *gin.Context, e *render.Engine) {
+	ready := e.Ready()
+	status := http.StatusOK
+	if !ready.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	g.JSON(status, ready)
+}
@@ -0,0 +1,147 @@
+package apiserver
+
+import (
+	"net/http"
+	"zipreport-server/pkg/browser"
+	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/zpt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerInfo describes the server version, capabilities and limits, so clients can validate jobs before uploading
+type ServerInfo struct {
+	Version     string       `json:"version"`
+	Browser     BrowserInfo  `json:"browser"`
+	Options     OptionsInfo  `json:"options"`
+	Limits      LimitsInfo   `json:"limits"`
+	Defaults    DefaultsInfo `json:"defaults"`
+	Concurrency int          `json:"concurrency"`
+	Queue       QueueInfo    `json:"queue"`
+	Features    FeaturesInfo `json:"features"`
+}
+
+type BrowserInfo struct {
+	Revision int    `json:"revision"` // bundled Chromium revision
+	Version  string `json:"version"`  // running browser version, empty if unknown
+}
+
+// OptionsInfo lists the accepted values of enumerated render options
+type OptionsInfo struct {
+	PageSizes      []string `json:"pageSizes"`
+	MarginStyles   []string `json:"marginStyles"`
+	OutputFormats  []string `json:"outputFormats"`
+	Units          []string `json:"units"`
+	WaitConditions []string `json:"waitConditions"`
+	Priorities     []string `json:"priorities"`
+	Presets        []string `json:"presets"`
+}
+
+type LimitsInfo struct {
+	MaxUploadBytes     int64   `json:"maxUploadBytes"`
+	MaxFileSize        int64   `json:"maxFileSize"` // decompressed size of a single ZPT entry
+	MaxDataSize        int     `json:"maxDataSize"`
+	MaxTemplateSize    int     `json:"maxTemplateSize"`
+	MaxTimeoutJob      int     `json:"maxTimeoutJob"`   // seconds
+	MaxTimeoutJs       int     `json:"maxTimeoutJs"`    // seconds
+	MaxSettlingTime    int     `json:"maxSettlingTime"` // milliseconds
+	MinPaperSize       float64 `json:"minPaperSize"`    // inches
+	MaxPaperSize       float64 `json:"maxPaperSize"`    // inches
+	MaxViewportSize    int     `json:"maxViewportSize"` // CSS pixels
+	MaxDeviceScale     float64 `json:"maxDeviceScale"`
+	MaxQuality         int     `json:"maxQuality"`
+	MaxPages           int     `json:"maxPages"` // paginated image export
+	MaxWaitConditions  int     `json:"maxWaitConditions"`
+	MaxNetworkIdleTime int     `json:"maxNetworkIdleTime"` // milliseconds
+}
+
+type DefaultsInfo struct {
+	TimeoutJob     int     `json:"timeoutJob"`     // seconds
+	TimeoutJs      int     `json:"timeoutJs"`      // seconds
+	SettlingTime   int     `json:"settlingTime"`   // milliseconds
+	ViewportWidth  int     `json:"viewportWidth"`  // CSS pixels
+	ViewportHeight int     `json:"viewportHeight"` // CSS pixels
+	DeviceScale    float64 `json:"deviceScale"`
+	Quality        int     `json:"quality"`
+	Priority       string  `json:"priority"`
+}
+
+type QueueInfo struct {
+	MaxDepth       int `json:"maxDepth"`       // 0 is unbounded
+	MaxWaitSeconds int `json:"maxWaitSeconds"` // 0 is unbounded
+}
+
+// FeaturesInfo reports the optional endpoints that are enabled
+type FeaturesInfo struct {
+	Templates bool `json:"templates"`
+	Jobs      bool `json:"jobs"`
+	Callbacks bool `json:"callbacks"`
+}
+
+// infoAction returns the server version, capabilities and limits
+func infoAction(g *gin.Context, e *render.Engine, svc *Services) {
+	g.JSON(http.StatusOK, newServerInfo(e, svc))
+}
+
+func newServerInfo(e *render.Engine, svc *Services) *ServerInfo {
+	browserVersion := e.BrowserVersion()
+	if len(browserVersion) == 0 {
+		// no browser connected yet
+		browserVersion = e.CheckBrowser().Version
+	}
+	size, _, _ := e.Queue.Stats()
+	maxDepth, maxWait := e.Queue.Limits()
+	return &ServerInfo{
+		Version: svc.Version,
+		Browser: BrowserInfo{
+			Revision: browser.Revision,
+			Version:  browserVersion,
+		},
+		Options: OptionsInfo{
+			PageSizes:      render.PageSizeNames(),
+			MarginStyles:   render.ValidMarginStyle,
+			OutputFormats:  render.ValidOutputFormats,
+			Units:          render.ValidUnits,
+			WaitConditions: render.ValidWaitConditions,
+			Priorities:     render.ValidPriority,
+			Presets:        render.PresetNames(),
+		},
+		Limits: LimitsInfo{
+			MaxUploadBytes:     MaxUploadBytes,
+			MaxFileSize:        zpt.MaxFileSize,
+			MaxDataSize:        render.MaxDataSize,
+			MaxTemplateSize:    render.MaxTemplateSize,
+			MaxTimeoutJob:      render.JobMaxTimeout,
+			MaxTimeoutJs:       render.JobMaxJsTimeout,
+			MaxSettlingTime:    render.JobMaxSettlingTime,
+			MinPaperSize:       render.MinPaperSize,
+			MaxPaperSize:       render.MaxPaperSize,
+			MaxViewportSize:    render.JobMaxViewportSize,
+			MaxDeviceScale:     render.JobMaxDeviceScale,
+			MaxQuality:         render.JobMaxQuality,
+			MaxPages:           render.JobMaxPages,
+			MaxWaitConditions:  render.WaitMaxConditions,
+			MaxNetworkIdleTime: render.WaitMaxNetworkIdle,
+		},
+		Defaults: DefaultsInfo{
+			TimeoutJob:     render.JobDefaultTimeout,
+			TimeoutJs:      render.JobDefaultJsTimeout,
+			SettlingTime:   render.JobDefaultSettlingTime,
+			ViewportWidth:  render.JobDefaultViewportWidth,
+			ViewportHeight: render.JobDefaultViewportHeight,
+			DeviceScale:    render.JobDefaultDeviceScale,
+			Quality:        render.JobDefaultQuality,
+			Priority:       render.PriorityNormal,
+		},
+		Concurrency: size,
+		Queue: QueueInfo{
+			MaxDepth:       maxDepth,
+			MaxWaitSeconds: int(maxWait.Seconds()),
+		},
+		Features: FeaturesInfo{
+			Templates: svc.Registry != nil,
+			Jobs:      svc.Jobs != nil,
+			Callbacks: svc.Webhooks != nil && svc.Webhooks.Enabled(),
+		},
+	}
+}
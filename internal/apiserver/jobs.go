@@ -0,0 +1,106 @@
+package apiserver
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"zipreport-server/pkg/jobs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
+)
+
+const (
+	// form fields - asynchronous jobs
+	ParamAsync       = "async"        // if true, /v2/render queues the job and returns 202 (bool)
+	ParamCallbackURL = "callback_url" // url receiving the job result (str)
+)
+
+// submitJobAction validates the render request and queues it, returning the job status immediately
+func submitJobAction(g *gin.Context, svc *Services) {
+	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, MaxUploadBytes)
+	submitJob(g, svc)
+}
+
+// submitJob queues the render request and writes the job status; if callback_url is set, the result is
+// posted to it once the job is finished. Job ids are generated by the server; the request id is only logged
+// to correlate the request with the job
+func submitJob(g *gin.Context, svc *Services) {
+	logger := log.FromContext(g)
+	reqId := requestId(g)
+	jobId := uuid.New()
+
+	callbackURL := g.PostForm(ParamCallbackURL)
+	if len(callbackURL) > 0 {
+		if svc.Webhooks == nil || !svc.Webhooks.Enabled() {
+			errBadRequest(g, "result callbacks are not enabled")
+			return
+		}
+		if err := svc.Webhooks.ValidateURL(callbackURL); err != nil {
+			errBadRequest(g, err.Error())
+			return
+		}
+	}
+
+	job, spool, err := buildSpooledRenderJob(g, jobId)
+	if err != nil {
+		logger.Error(err, "error building render job", log.KV{"reqId": reqId, "jobId": jobId})
+		errBuildJob(g, err)
+		return
+	}
+	info, err := svc.Jobs.Submit(job, spool, callbackURL)
+	if err != nil {
+		logger.Error(err, "error queueing render job", log.KV{"reqId": reqId, "jobId": jobId})
+		errQueue(g, err)
+		return
+	}
+	if len(callbackURL) > 0 {
+		// only the host is logged, as the url may carry credentials
+		u, _ := url.Parse(callbackURL)
+		logger.Info("result callback registered", log.KV{"reqId": reqId, "jobId": jobId, "callbackHost": u.Host})
+	}
+	logger.Info("render job queued", log.KV{"reqId": reqId, "jobId": info.Id})
+	g.Header("Location", "/v2/jobs/"+info.Id)
+	g.JSON(http.StatusAccepted, info)
+}
+
+// jobStatusAction writes the status of a job; jobs of other clients are not found
+func jobStatusAction(g *gin.Context, mgr *jobs.Manager) {
+	info, err := mgr.Get(g.Param("id"), clientName(g))
+	if err != nil {
+		errJobNotFound(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, info)
+}
+
+// jobResultAction writes the output of a finished job; failed jobs return the same error response as /v2/render
+func jobResultAction(g *gin.Context, mgr *jobs.Manager) {
+	info, job, result, err := mgr.Result(g.Param("id"), clientName(g))
+	if err != nil {
+		errJobNotFound(g, err)
+		return
+	}
+	if result == nil {
+		body := errorBody(g, ErrCodeNotFinished, "job not finished")
+		body["status"] = info.Status
+		g.JSON(http.StatusConflict, body)
+		return
+	}
+	if !result.Success {
+		errRenderFailed(g, result)
+		return
+	}
+	if err = writeOutput(g, job, result); err != nil {
+		log.FromContext(g).Error(err, "error writing output to api response", log.KV{"reqId": requestId(g), "jobId": info.Id})
+	}
+}
+
+func errJobNotFound(g *gin.Context, err error) {
+	if errors.Is(err, jobs.ErrNotFound) {
+		errNotFound(g, err)
+		return
+	}
+	errServerError(g)
+}
@@ -2,6 +2,12 @@ package apiserver
 
 import (
 	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"zipreport-server/pkg/render"
 	"zipreport-server/pkg/zpt"
@@ -12,38 +18,53 @@ import (
 
 const (
 	// form fields - render
-	ParamReport       = "report"            // report file
-	ParamIndexFile    = "script"            // main script (str)
-	ParamPageSize     = "page_size"         // page size (str)
-	ParamMarginStyle  = "margins"           // margin style (str)
-	ParamMarginLeft   = "margin_left"       // left margin in inches (str)
-	ParamMarginRight  = "margin_right"      // right margin in inches (str)
-	ParamMarginTop    = "margin_top"        // top margin in inches (str)
-	ParamMarginBottom = "margin_bottom"     // bottom margin in inches (str)
-	ParamLandscape    = "landscape"         // page orientation (bool)
-	ParamSettlingTime = "settling_time"     // job settling time, in milliseconds (int)
-	ParamJobTimeout   = "timeout_job"       // job timeout, in seconds(int)
-	ParamJsTimeout    = "timeout_js"        // js event timeout, in seconds(int)
-	ParamJsEvent      = "js_event"          // usage of js triggered event (bool)
-	IgnoreSslErr      = "ignore_ssl_errors" // ignore ssl errors (bool)
+	ParamReport       = "report"               // report file
+	ParamPreset       = "preset"               // server-side preset name (str)
+	ParamIndexFile    = "script"               // main script (str)
+	ParamPageSize     = "page_size"            // page size (str)
+	ParamPageWidth    = "page_width"           // custom page width, with optional unit (str)
+	ParamPageHeight   = "page_height"          // custom page height, with optional unit (str)
+	ParamMarginStyle  = "margins"              // margin style (str)
+	ParamMarginLeft   = "margin_left"          // left margin, with optional unit (str)
+	ParamMarginRight  = "margin_right"         // right margin, with optional unit (str)
+	ParamMarginTop    = "margin_top"           // top margin, with optional unit (str)
+	ParamMarginBottom = "margin_bottom"        // bottom margin, with optional unit (str)
+	ParamLandscape    = "landscape"            // page orientation (bool)
+	ParamSettlingTime = "settling_time"        // job settling time, in milliseconds (int)
+	ParamJobTimeout   = "timeout_job"          // job timeout, in seconds(int)
+	ParamJsTimeout    = "timeout_js"           // js event timeout, in seconds(int)
+	ParamJsEvent      = "js_event"             // usage of js triggered event (bool)
+	ParamJsStrict     = "js_event_strict"      // fail the job on ready timeout (bool)
+	IgnoreSslErr      = "ignore_ssl_errors"    // ignore ssl errors (bool)
+	ParamHeader       = "header_template"      // header html template (str)
+	ParamFooter       = "footer_template"      // footer html template (str)
+	ParamHeaderHeight = "header_height"        // header height, with optional unit (str)
+	ParamFooterHeight = "footer_height"        // footer height, with optional unit (str)
+	ParamBackground   = "print_background"     // print background graphics (bool)
+	ParamCSSPageSize  = "prefer_css_page_size" // use CSS @page size (bool)
+	ParamOutputFormat = "output_format"        // output format, pdf/png/jpeg/webp (str)
+	ParamViewportW    = "viewport_width"       // screenshot viewport width, in CSS pixels (int)
+	ParamViewportH    = "viewport_height"      // screenshot viewport height, in CSS pixels (int)
+	ParamDeviceScale  = "device_scale"         // screenshot device scale factor (float)
+	ParamQuality      = "quality"              // jpeg/webp quality, 0-100 (int)
+	ParamFullPage     = "full_page"            // capture whole page instead of viewport (bool)
+	ParamTransparent  = "transparent"          // transparent background, png/webp only (bool)
+	ParamPaginate     = "paginate"             // one image per printed page, as a zip archive (bool)
+	ParamWaitFor      = "wait_for"             // readiness condition, type[:value]; repeatable (str)
+	ParamDiagnostics  = "diagnostics"          // return console output, page errors and missing files (bool)
+	ParamData         = "data"                 // JSON data exposed to the page (str)
+	ParamDataFile     = "data.json"            // JSON data exposed to the page, as a file part (file)
+	ParamPriority     = "priority"             // queue priority class, high/normal/low (str)
 )
 
 var errInvalidPageSize = errors.New("invalid page size")
 var errInvalidMarginStyle = errors.New("invalid margin style")
 var errInvalidMarginValue = errors.New("invalid margin value")
-
-/**
- * naive needle in <set>, for small sets
- * Returns true if needle exists in list
- */
-func strExists(needle string, list []string) bool {
-	for _, b := range list {
-		if b == needle {
-			return true
-		}
-	}
-	return false
-}
+var errInvalidPreset = errors.New("invalid preset")
+var errInvalidOutputFormat = errors.New("invalid output format")
+var errInvalidPaginate = errors.New("paginate requires an image output format")
+var errInvalidTemplateHeight = errors.New("invalid header or footer height")
+var errInvalidPriority = errors.New("invalid priority")
 
 func optionalIntValue(ctx *gin.Context, name string, defaultValue int) int {
 	if v, exists := ctx.GetPostForm(name); !exists {
@@ -70,6 +91,22 @@ func optionalBoolValue(ctx *gin.Context, name string, defaultValue bool) bool {
 	return b
 }
 
+func optionalFloatValue(ctx *gin.Context, name string, defaultValue float64) float64 {
+	v, exists := ctx.GetPostForm(name)
+	if !exists {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return defaultValue
+	}
+	return f
+}
+
+func clampFloat(v, min, max float64) float64 {
+	return math.Max(min, math.Min(v, max))
+}
+
 func clampInt(v, min, max int) int {
 	if v < min {
 		return min
@@ -80,18 +117,71 @@ func clampInt(v, min, max int) int {
 	return v
 }
 
-func strFloatValue(ctx *gin.Context, name string, defaultValue float64) (float64, error) {
+/**
+ * Parse length with optional unit suffix (in, cm, mm, px, pt)
+ * Returns the value in inches
+ */
+func strLengthValue(ctx *gin.Context, name string, defaultValue float64) (float64, error) {
 	if v, exists := ctx.GetPostForm(name); !exists {
 		return defaultValue, nil
 	} else {
-		return strconv.ParseFloat(v, 64)
+		return render.ParseLength(v)
 	}
 }
 
+/**
+ * Read header/footer template from the form field, or from the named file inside the ZPT
+ * Returns defaultValue if the form field doesn't exist and defaultValue is not empty
+ * Returns an empty string if neither exists
+ */
+func templateValue(ctx *gin.Context, name string, reader *zpt.ZptReader, fileName string, defaultValue string) (string, error) {
+	if v, exists := ctx.GetPostForm(name); exists {
+		if len(v) > render.MaxTemplateSize {
+			return "", render.ErrInvalidTemplate
+		}
+		return v, nil
+	}
+	if len(defaultValue) > 0 {
+		return defaultValue, nil
+	}
+	buf, err := reader.ReadFile(fileName)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(buf) > render.MaxTemplateSize {
+		return "", render.ErrInvalidTemplate
+	}
+	return string(buf), nil
+}
+
+/**
+ * Read the per-request JSON data from the data form field, or from the data.json file part
+ * Returns nil if neither exists
+ */
+func dataValue(ctx *gin.Context) ([]byte, error) {
+	if v, exists := ctx.GetPostForm(ParamData); exists {
+		return []byte(v), nil
+	}
+	file, _, err := ctx.Request.FormFile(ParamDataFile)
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	// read one extra byte, so oversized data is detected
+	return io.ReadAll(io.LimitReader(file, render.MaxDataSize+1))
+}
+
 /**
  * Assemble render.Job() from Request
  * To simplify implementation of optional fields and validation of specific values,
  * Bind() is not used
+ * If a preset is specified, its values are applied first, and are overridden by any request field
  */
 func buildRenderJob(c *gin.Context, reqId uuid.UUID) (*render.Job, error) {
 	// validate zpt stream
@@ -104,48 +194,198 @@ func buildRenderJob(c *gin.Context, reqId uuid.UUID) (*render.Job, error) {
 	if err != nil {
 		return nil, err
 	}
+	return buildJobOptions(c, reader, reqId)
+}
+
+/**
+ * Assemble render.Job() from Request, for jobs that outlive the request
+ * The upload is copied to a temporary file, as multipart files are removed when the request ends;
+ * the returned file is read by the job, and must be closed and removed once the job is finished
+ */
+func buildSpooledRenderJob(c *gin.Context, jobId uuid.UUID) (*render.Job, *os.File, error) {
+	report, _, err := c.Request.FormFile(ParamReport)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = report.Close() }()
+	spool, err := os.CreateTemp("", "zipreport-job-*.zpt")
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}
+	size, err := io.Copy(spool, report)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	reader, err := zpt.NewZptReader(spool, size)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	job, err := buildJobOptions(c, reader, jobId)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return job, spool, nil
+}
+
+/**
+ * Assemble render.Job() for an already opened ZPT, from the request fields
+ */
+func buildJobOptions(c *gin.Context, reader *zpt.ZptReader, reqId uuid.UUID) (*render.Job, error) {
+	var err error
 	job := render.NewRenderJob(reader, reqId)
 
+	// apply preset
+	var preset *render.Preset
+	if name, exists := c.GetPostForm(ParamPreset); exists {
+		var ok bool
+		if preset, ok = render.GetPreset(name); !ok {
+			return nil, errInvalidPreset
+		}
+		if err = preset.Apply(job); err != nil {
+			return nil, err
+		}
+		job.Preset = name
+	}
+
+	// validate output format
+	if format, exists := c.GetPostForm(ParamOutputFormat); exists {
+		job.OutputFormat = format
+	}
+	if !slices.Contains(render.ValidOutputFormats, job.OutputFormat) {
+		return nil, errInvalidOutputFormat
+	}
+	// page size and margins are only mandatory for pdf output
+	pdfOutput := job.OutputFormat == render.OutputPDF
+
 	// validate page size
-	job.PageSize = c.Request.PostFormValue(ParamPageSize)
-	if !strExists(job.PageSize, render.ValidPageSizes) {
+	// if CSS page size is preferred, page size is only a fallback for documents without @page size
+	job.PreferCSSPageSize = optionalBoolValue(c, ParamCSSPageSize, job.PreferCSSPageSize)
+	if pageSize, exists := c.GetPostForm(ParamPageSize); exists {
+		if render.IsValidPageSize(pageSize) {
+			job.PageSize = pageSize
+		} else if !job.PreferCSSPageSize {
+			return nil, errInvalidPageSize
+		}
+	} else if (preset == nil || len(preset.PageSize) == 0) && !job.PreferCSSPageSize && pdfOutput {
 		return nil, errInvalidPageSize
 	}
+	if job.PageSize == render.PageCustom {
+		job.PageWidth, err = strLengthValue(c, ParamPageWidth, job.PageWidth)
+		if err != nil {
+			return nil, err
+		}
+		job.PageHeight, err = strLengthValue(c, ParamPageHeight, job.PageHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// validate margin style
-	job.MarginStyle = c.Request.PostFormValue(ParamMarginStyle)
-	if !strExists(job.MarginStyle, render.ValidMarginStyle) {
+	if marginStyle, exists := c.GetPostForm(ParamMarginStyle); exists || (pdfOutput && (preset == nil || len(preset.Margins) == 0)) {
+		job.MarginStyle = marginStyle
+	}
+	if !slices.Contains(render.ValidMarginStyle, job.MarginStyle) {
 		return nil, errInvalidMarginStyle
 	}
-	job.MarginLeft, err = strFloatValue(c, ParamMarginLeft, 0)
+	job.MarginLeft, err = strLengthValue(c, ParamMarginLeft, job.MarginLeft)
 	if err != nil || job.MarginLeft < 0 {
 		return nil, errInvalidMarginValue
 	}
 
-	job.MarginRight, err = strFloatValue(c, ParamMarginRight, 0)
+	job.MarginRight, err = strLengthValue(c, ParamMarginRight, job.MarginRight)
 	if err != nil || job.MarginRight < 0 {
 		return nil, errInvalidMarginValue
 	}
-	job.MarginTop, err = strFloatValue(c, ParamMarginTop, 0)
+	job.MarginTop, err = strLengthValue(c, ParamMarginTop, job.MarginTop)
 	if err != nil || job.MarginTop < 0 {
 		return nil, errInvalidMarginValue
 	}
-	job.MarginBottom, err = strFloatValue(c, ParamMarginBottom, 0)
+	job.MarginBottom, err = strLengthValue(c, ParamMarginBottom, job.MarginBottom)
 	if err != nil || job.MarginBottom < 0 {
 		return nil, errInvalidMarginValue
 	}
 
-	// validate main script
-	job.IndexFile = c.Request.PostFormValue(ParamIndexFile)
-	if len(job.IndexFile) == 0 {
-		job.IndexFile = zpt.DefaultScriptName
+	// validate main script, before the job takes a render slot
+	if job.IndexFile, err = reader.ResolveIndex(c.Request.PostFormValue(ParamIndexFile)); err != nil {
+		return nil, err
 	}
 
-	job.Landscape = optionalBoolValue(c, ParamLandscape, false)
-	job.JobSettlingTimeMs = clampInt(optionalIntValue(c, ParamSettlingTime, render.JobDefaultSettlingTime), 0, render.JobMaxSettlingTime)
-	job.JobTimeoutS = clampInt(optionalIntValue(c, ParamJobTimeout, render.JobDefaultTimeout), 1, render.JobMaxTimeout)
-	job.JsTimeoutS = clampInt(optionalIntValue(c, ParamJsTimeout, render.JobDefaultJsTimeout), 1, render.JobMaxJsTimeout)
-	job.UseJSEvent = optionalBoolValue(c, ParamJsEvent, false)
-	job.IgnoreSSLErrors = optionalBoolValue(c, IgnoreSslErr, false)
+	job.Landscape = optionalBoolValue(c, ParamLandscape, job.Landscape)
+	job.JobSettlingTimeMs = clampInt(optionalIntValue(c, ParamSettlingTime, job.JobSettlingTimeMs), 0, render.JobMaxSettlingTime)
+	job.JobTimeoutS = clampInt(optionalIntValue(c, ParamJobTimeout, job.JobTimeoutS), 1, render.JobMaxTimeout)
+	job.JsTimeoutS = clampInt(optionalIntValue(c, ParamJsTimeout, job.JsTimeoutS), 1, render.JobMaxJsTimeout)
+	job.UseJSEvent = optionalBoolValue(c, ParamJsEvent, job.UseJSEvent)
+	job.StrictReady = optionalBoolValue(c, ParamJsStrict, job.StrictReady)
+	if conditions, exists := c.GetPostFormArray(ParamWaitFor); exists {
+		if job.WaitFor, err = render.ParseWaitConditions(conditions); err != nil {
+			return nil, err
+		}
+	}
+	job.IgnoreSSLErrors = optionalBoolValue(c, IgnoreSslErr, job.IgnoreSSLErrors)
+	job.Diagnostics = optionalBoolValue(c, ParamDiagnostics, job.Diagnostics)
+
+	// scheduling
+	if priority, exists := c.GetPostForm(ParamPriority); exists {
+		job.Priority = priority
+	}
+	if !slices.Contains(render.ValidPriority, job.Priority) {
+		return nil, errInvalidPriority
+	}
+	// priorities above the limit of the api key are lowered, not rejected
+	job.Priority = render.ClampPriority(job.Priority, c.GetString(ContextMaxPriority))
+	job.Client = clientName(c)
+
+	// per-request data, validated before a browser slot is taken
+	data, err := dataValue(c)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if err = job.SetData(data); err != nil {
+			return nil, err
+		}
+	}
+	job.PrintBackground = optionalBoolValue(c, ParamBackground, job.PrintBackground)
+
+	// screenshot options
+	job.ViewportWidth = clampInt(optionalIntValue(c, ParamViewportW, job.ViewportWidth), 1, render.JobMaxViewportSize)
+	job.ViewportHeight = clampInt(optionalIntValue(c, ParamViewportH, job.ViewportHeight), 1, render.JobMaxViewportSize)
+	job.DeviceScaleFactor = clampFloat(optionalFloatValue(c, ParamDeviceScale, job.DeviceScaleFactor), render.JobMinDeviceScale, render.JobMaxDeviceScale)
+	job.Quality = clampInt(optionalIntValue(c, ParamQuality, job.Quality), 0, render.JobMaxQuality)
+	job.FullPage = optionalBoolValue(c, ParamFullPage, job.FullPage)
+	job.TransparentBackground = optionalBoolValue(c, ParamTransparent, job.TransparentBackground)
+	job.Paginate = optionalBoolValue(c, ParamPaginate, job.Paginate)
+	if job.Paginate && !job.IsImage() {
+		return nil, errInvalidPaginate
+	}
+
+	// header & footer templates
+	// request fields take precedence over presets, and presets over the files inside the ZPT
+	if job.HeaderTemplate, err = templateValue(c, ParamHeader, reader, zpt.DefaultHeaderName, job.HeaderTemplate); err != nil {
+		return nil, err
+	}
+	if job.FooterTemplate, err = templateValue(c, ParamFooter, reader, zpt.DefaultFooterName, job.FooterTemplate); err != nil {
+		return nil, err
+	}
+	job.HeaderHeight, err = strLengthValue(c, ParamHeaderHeight, job.HeaderHeight)
+	if err != nil || job.HeaderHeight < 0 {
+		return nil, errInvalidTemplateHeight
+	}
+	job.FooterHeight, err = strLengthValue(c, ParamFooterHeight, job.FooterHeight)
+	if err != nil || job.FooterHeight < 0 {
+		return nil, errInvalidTemplateHeight
+	}
+
+	// validate paper size and margins
+	if err = job.Validate(); err != nil {
+		return nil, err
+	}
 
 	return job, nil
 }
@@ -2,8 +2,11 @@ package apiserver
 
 import (
 	"crypto/subtle"
+	"zipreport-server/pkg/jobs"
 	"zipreport-server/pkg/monitor"
+	"zipreport-server/pkg/registry"
 	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/webhook"
 
 	"github.com/gin-gonic/gin"
 	"github.com/oddbit-project/blueprint/log"
@@ -22,28 +25,91 @@ const (
 // an Options map, so they are configured here and applied in NewApiServer.
 type ApiServerConfig struct {
 	httpserver.ServerConfig
-	AuthTokenHeader        string `json:"authTokenHeader"`
-	AuthTokenSecret        string `json:"authTokenSecret"`
-	DefaultSecurityHeaders bool   `json:"defaultSecurityHeaders"`
+	AuthTokenHeader        string                   `json:"authTokenHeader"`
+	AuthTokenSecret        string                   `json:"authTokenSecret"`
+	AuthTokens             map[string]*ApiKeyConfig `json:"authTokens"` // additional named API keys
+	DefaultSecurityHeaders bool                     `json:"defaultSecurityHeaders"`
+}
+
+// ApiKeyConfig is a named API key; the name identifies the client for fair scheduling
+type ApiKeyConfig struct {
+	Secret        string `json:"secret"`
+	Weight        int    `json:"weight"`        // share of render slots, relative to other clients of the same priority
+	MaxQueueDepth int    `json:"maxQueueDepth"` // maximum waiting jobs of the client; 0 is only bounded by the queue depth
+	MaxPriority   string `json:"maxPriority"`   // highest priority class the client may request; empty is unbounded
+}
+
+// ContextClient is the gin context key holding the name of the authenticated client
+const ContextClient = "zptClient"
+
+// ContextMaxPriority is the gin context key holding the highest priority class of the authenticated client
+const ContextMaxPriority = "zptMaxPriority"
+
+// apiKey is a client token
+type apiKey struct {
+	name        string
+	secret      string
+	maxPriority string
 }
 
 // constantTimeToken is an auth.Provider that compares the token header against
-// the configured secret in constant time, avoiding the timing side-channel of
-// the framework's default string comparison.
+// the configured secrets in constant time, avoiding the timing side-channel of
+// the framework's default string comparison. The matching client name is stored
+// in the request context.
 type constantTimeToken struct {
 	header string
-	key    string
+	keys   []apiKey
 }
 
 func (a constantTimeToken) CanAccess(c *gin.Context) bool {
-	if len(a.key) == 0 {
+	got := []byte(c.Request.Header.Get(a.header))
+	var client *apiKey
+	// every key is compared, so the response time doesn't depend on which key matched
+	for i, key := range a.keys {
+		if len(key.secret) > 0 && subtle.ConstantTimeCompare(got, []byte(key.secret)) == 1 {
+			client = &a.keys[i]
+		}
+	}
+	if client == nil {
 		return false
 	}
-	got := c.Request.Header.Get(a.header)
-	return subtle.ConstantTimeCompare([]byte(got), []byte(a.key)) == 1
+	c.Set(ContextClient, client.name)
+	c.Set(ContextMaxPriority, client.maxPriority)
+	return true
 }
 
-func NewApiServer(cfg *ApiServerConfig, engine *render.Engine, metrics *monitor.Metrics, logger *log.Logger) (*httpserver.Server, error) {
+// clientName returns the name of the authenticated client
+func clientName(c *gin.Context) string {
+	if name := c.GetString(ContextClient); len(name) > 0 {
+		return name
+	}
+	return render.DefaultClient
+}
+
+// acceptJobs rejects new render jobs once the engine is draining
+func acceptJobs(engine *render.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if engine.Draining() {
+			errShuttingDown(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Services holds the optional components exposed by the API; endpoints of nil components are not registered
+type Services struct {
+	Version  string              // server version, reported by /v2/info
+	Registry *registry.Registry  // stored templates
+	Jobs     *jobs.Manager       // asynchronous jobs
+	Webhooks *webhook.Dispatcher // job result callbacks
+}
+
+func NewApiServer(cfg *ApiServerConfig, engine *render.Engine, metrics *monitor.Metrics, svc *Services, logger *log.Logger) (*httpserver.Server, error) {
+	if svc == nil {
+		svc = &Services{}
+	}
+
 	srv, err := httpserver.NewServer(&cfg.ServerConfig, logger)
 	if err != nil {
 		return nil, err
@@ -53,18 +119,72 @@ func NewApiServer(cfg *ApiServerConfig, engine *render.Engine, metrics *monitor.
 		srv.UseDefaultSecurityHeaders()
 	}
 
+	// health probes are registered before the auth middleware, so they don't require authentication
+	srv.Router.GET("/healthz", healthzAction)
+	srv.Router.GET("/readyz", func(g *gin.Context) {
+		readyzAction(g, engine)
+	})
+
 	header := cfg.AuthTokenHeader
 	if header == "" {
 		header = auth.DefaultTokenAuthHeader
 	}
 	if cfg.AuthTokenSecret != "" {
-		srv.UseAuth(constantTimeToken{header: header, key: cfg.AuthTokenSecret})
+		keys := []apiKey{{name: render.DefaultClient, secret: cfg.AuthTokenSecret}}
+		for name, key := range cfg.AuthTokens {
+			keys = append(keys, apiKey{name: name, secret: key.Secret, maxPriority: key.MaxPriority})
+			engine.Queue.SetClientWeight(name, key.Weight)
+			engine.Queue.SetClientMaxDepth(name, key.MaxQueueDepth)
+		}
+		srv.UseAuth(constantTimeToken{header: header, keys: keys})
 	}
 
 	v := srv.Group("v2")
 	{
-		v.POST("/render", func(g *gin.Context) {
-			renderAction(g, engine, metrics)
+		v.POST("/render", acceptJobs(engine), func(g *gin.Context) {
+			renderAction(g, engine, metrics, svc)
+		})
+		v.GET("/info", func(g *gin.Context) {
+			infoAction(g, engine, svc)
+		})
+		v.POST("/validate", validateAction)
+	}
+
+	if reg := svc.Registry; reg != nil {
+		t := v.Group("templates")
+		t.POST("", func(g *gin.Context) {
+			createTemplateAction(g, reg)
+		})
+		t.GET("", func(g *gin.Context) {
+			listTemplatesAction(g, reg)
+		})
+		t.GET("/:id", func(g *gin.Context) {
+			getTemplateAction(g, reg)
+		})
+		t.DELETE("/:id", func(g *gin.Context) {
+			deleteTemplateAction(g, reg)
+		})
+		t.POST("/:id/versions", func(g *gin.Context) {
+			addVersionAction(g, reg)
+		})
+		t.DELETE("/:id/versions/:version", func(g *gin.Context) {
+			deleteVersionAction(g, reg)
+		})
+		t.POST("/:id/render", acceptJobs(engine), func(g *gin.Context) {
+			renderTemplateAction(g, engine, metrics, reg)
+		})
+	}
+
+	if mgr := svc.Jobs; mgr != nil {
+		j := v.Group("jobs")
+		j.POST("", acceptJobs(engine), func(g *gin.Context) {
+			submitJobAction(g, svc)
+		})
+		j.GET("/:id", func(g *gin.Context) {
+			jobStatusAction(g, mgr)
+		})
+		j.GET("/:id/result", func(g *gin.Context) {
+			jobResultAction(g, mgr)
 		})
 	}
 
@@ -0,0 +1,236 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"zipreport-server/pkg/monitor"
+	"zipreport-server/pkg/registry"
+	"zipreport-server/pkg/render"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
+)
+
+const (
+	// form fields - templates
+	ParamTemplateName = "name"    // template name (str)
+	ParamVersion      = "version" // template version, latest if omitted (int)
+)
+
+var errInvalidRenderBody = errors.New("invalid render request body")
+
+// templateId validates the template id path parameter
+func templateId(g *gin.Context) (string, bool) {
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		errNotFound(g, registry.ErrNotFound)
+		return "", false
+	}
+	return id.String(), true
+}
+
+// errRegistry writes the response for a registry error
+func errRegistry(g *gin.Context, err error) {
+	if errors.Is(err, registry.ErrNotFound) {
+		errNotFound(g, err)
+		return
+	}
+	log.FromContext(g).Error(err, "template registry error")
+	errServerError(g)
+}
+
+// createTemplateAction stores a new template from the report form file
+func createTemplateAction(g *gin.Context, reg *registry.Registry) {
+	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, MaxUploadBytes)
+	report, _, err := g.Request.FormFile(ParamReport)
+	if err != nil {
+		errBadRequest(g, "missing report file")
+		return
+	}
+	defer func() { _ = report.Close() }()
+	tpl, err := reg.Create(g.Request.PostFormValue(ParamTemplateName), report)
+	if err != nil {
+		log.FromContext(g).Error(err, "error creating template")
+		errBadRequest(g, "invalid template")
+		return
+	}
+	g.JSON(http.StatusCreated, tpl)
+}
+
+// addVersionAction stores a new version of a template from the report form file
+func addVersionAction(g *gin.Context, reg *registry.Registry) {
+	id, ok := templateId(g)
+	if !ok {
+		return
+	}
+	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, MaxUploadBytes)
+	report, _, err := g.Request.FormFile(ParamReport)
+	if err != nil {
+		errBadRequest(g, "missing report file")
+		return
+	}
+	defer func() { _ = report.Close() }()
+	tpl, err := reg.AddVersion(id, report)
+	if err != nil {
+		if errors.Is(err, registry.ErrNotFound) {
+			errRegistry(g, err)
+			return
+		}
+		log.FromContext(g).Error(err, "error adding template version", log.KV{"templateId": id})
+		errBadRequest(g, "invalid template")
+		return
+	}
+	g.JSON(http.StatusCreated, tpl)
+}
+
+func listTemplatesAction(g *gin.Context, reg *registry.Registry) {
+	g.JSON(http.StatusOK, gin.H{"templates": reg.List()})
+}
+
+func getTemplateAction(g *gin.Context, reg *registry.Registry) {
+	id, ok := templateId(g)
+	if !ok {
+		return
+	}
+	tpl, err := reg.Get(id)
+	if err != nil {
+		errRegistry(g, err)
+		return
+	}
+	g.JSON(http.StatusOK, tpl)
+}
+
+func deleteTemplateAction(g *gin.Context, reg *registry.Registry) {
+	id, ok := templateId(g)
+	if !ok {
+		return
+	}
+	if err := reg.Delete(id); err != nil {
+		errRegistry(g, err)
+		return
+	}
+	g.Status(http.StatusNoContent)
+}
+
+func deleteVersionAction(g *gin.Context, reg *registry.Registry) {
+	id, ok := templateId(g)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(g.Param("version"))
+	if err != nil {
+		errRegistry(g, registry.ErrNotFound)
+		return
+	}
+	if err = reg.DeleteVersion(id, version); err != nil {
+		errRegistry(g, err)
+		return
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// renderTemplateAction renders a stored template; render options are read from the form fields, or from a JSON body
+func renderTemplateAction(g *gin.Context, e *render.Engine, m *monitor.Metrics, reg *registry.Registry) {
+	id, ok := templateId(g)
+	if !ok {
+		return
+	}
+	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, MaxUploadBytes)
+	reqId := requestId(g)
+	logger := log.FromContext(g)
+
+	m.TotalOps.Inc() // update metrics
+	if g.ContentType() == gin.MIMEJSON {
+		if err := jsonFormValues(g); err != nil {
+			logger.Error(err, "error parsing render request", log.KV{"reqId": reqId})
+			errBadRequest(g, "error building render job")
+			return
+		}
+	}
+	version := optionalIntValue(g, ParamVersion, 0)
+	reader, release, err := reg.Acquire(id, version)
+	if err != nil {
+		errRegistry(g, err)
+		return
+	}
+	defer release()
+
+	ticket, err := e.Queue.Reserve(clientName(g))
+	if err != nil {
+		logger.Warn("render job rejected", log.KV{"reqId": reqId, "error": err.Error()})
+		errQueue(g, err)
+		return
+	}
+	defer ticket.Release()
+
+	job, err := buildJobOptions(g, reader, reqId)
+	if err != nil {
+		logger.Error(err, "error building render job", log.KV{"reqId": reqId, "templateId": id})
+		errBuildJob(g, err)
+		return
+	}
+	job.Ticket = ticket
+	runRenderJob(g, e, m, job)
+}
+
+/**
+ * Convert a JSON render request body to form values, so it can be processed as a form
+ * Scalar values are used as-is, arrays are repeated fields (e.g. wait_for), and the "data" value is kept as JSON
+ */
+func jsonFormValues(g *gin.Context) error {
+	body := map[string]json.RawMessage{}
+	if err := json.NewDecoder(g.Request.Body).Decode(&body); err != nil {
+		return err
+	}
+	values := url.Values{}
+	for key, raw := range body {
+		if key == ParamData {
+			values.Set(key, string(raw))
+			continue
+		}
+		raw = bytes.TrimSpace(raw)
+		switch {
+		case len(raw) > 0 && raw[0] == '[':
+			var list []json.RawMessage
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return err
+			}
+			for _, item := range list {
+				v, err := jsonScalar(item)
+				if err != nil {
+					return err
+				}
+				values.Add(key, v)
+			}
+		default:
+			v, err := jsonScalar(raw)
+			if err != nil {
+				return err
+			}
+			values.Set(key, v)
+		}
+	}
+	g.Request.PostForm = values
+	g.Request.Form = values
+	return nil
+}
+
+// jsonScalar converts a JSON string, number or boolean to its form value
+func jsonScalar(raw json.RawMessage) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case bool, float64:
+		return string(bytes.TrimSpace(raw)), nil
+	}
+	return "", errInvalidRenderBody
+}
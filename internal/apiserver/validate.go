@@ -0,0 +1,30 @@
+package apiserver
+
+import (
+	"net/http"
+	"zipreport-server/pkg/zpt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oddbit-project/blueprint/log"
+)
+
+// validateAction checks the uploaded report without rendering it, and returns the validation report; the
+// response is 200 for any readable archive, and clients check "valid"
+func validateAction(g *gin.Context) {
+	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, MaxUploadBytes)
+	report, info, err := g.Request.FormFile(ParamReport)
+	if err != nil {
+		errBuildJob(g, err)
+		return
+	}
+	defer func() { _ = report.Close() }()
+
+	reader, err := zpt.NewZptReader(report, info.Size)
+	if err != nil {
+		log.FromContext(g).Warn("invalid report archive", log.KV{"reqId": requestId(g), "error": err.Error()})
+		errBuildJob(g, err)
+		return
+	}
+	defer reader.Destroy()
+	g.JSON(http.StatusOK, reader.Validate(g.Request.PostFormValue(ParamIndexFile)))
+}
@@ -1,9 +1,13 @@
 package internal
 
 import (
+	"time"
 	"zipreport-server/internal/apiserver"
+	"zipreport-server/pkg/jobs"
 	"zipreport-server/pkg/monitor"
+	"zipreport-server/pkg/registry"
 	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/webhook"
 
 	"github.com/oddbit-project/blueprint"
 	"github.com/oddbit-project/blueprint/config/provider"
@@ -20,6 +24,8 @@ type CliArgs struct {
 type ZipReport struct {
 	*blueprint.Container
 	api    *httpserver.Server
+	engine *render.Engine
+	grace  time.Duration // shutdown drain grace period
 	args   *CliArgs
 	logger *log.Logger
 }
@@ -38,7 +44,7 @@ func NewZipReport(args *CliArgs, logger *log.Logger) (*ZipReport, error) {
 	}, nil
 }
 
-func (z *ZipReport) Build(appName string) {
+func (z *ZipReport) Build(appName string, version string) {
 	var err error
 	z.logger.Infof("initializing %s...", appName)
 
@@ -56,6 +62,9 @@ func (z *ZipReport) Build(appName string) {
 	z.logger, err = cfg.Logging.Logger()
 	z.AbortFatal(err)
 
+	// register configured paper sizes and presets
+	z.AbortFatal(cfg.ZipReport.RegisterRenderOptions())
+
 	// initialize metrics
 	metrics := monitor.NewMetrics()
 
@@ -67,6 +76,10 @@ func (z *ZipReport) Build(appName string) {
 	if cfg.ZipReport.EnableHttpDebugging {
 		zptEngine.EnableHttpDebugging()
 	}
+	zptEngine.SetQueueLimits(cfg.ZipReport.QueueDepth, time.Duration(cfg.ZipReport.QueueMaxWaitSeconds)*time.Second)
+	zptEngine.SetReadyRequireFreeSlot(cfg.ZipReport.ReadyRequireFreeSlot)
+	z.engine = zptEngine
+	z.grace = time.Duration(cfg.ZipReport.ShutdownGraceSeconds) * time.Second
 	// register engine destructor to release browsers and ephemeral servers on shutdown
 	blueprint.RegisterDestructor(func() error {
 		zptEngine.Shutdown()
@@ -94,8 +107,39 @@ func (z *ZipReport) Build(appName string) {
 		})
 	}
 
+	// initialize stored template registry
+	var reg *registry.Registry
+	if len(cfg.ZipReport.TemplateDir) > 0 {
+		reg, err = registry.NewRegistry(cfg.ZipReport.TemplateDir, z.logger)
+		z.AbortFatal(err)
+		blueprint.RegisterDestructor(func() error {
+			reg.Close()
+			return nil
+		})
+	}
+
+	// initialize persistent job store
+	var jobStore jobs.Store
+	if len(cfg.ZipReport.JobStoreDir) > 0 {
+		jobStore, err = jobs.NewFileStore(cfg.ZipReport.JobStoreDir, z.logger)
+		z.AbortFatal(err)
+	}
+
+	// initialize asynchronous job manager and result callbacks
+	jobManager := jobs.NewManager(z.Context, zptEngine, metrics, time.Duration(cfg.ZipReport.JobRetentionSeconds)*time.Second, jobStore, z.logger)
+	webhooks := webhook.NewDispatcher(z.Context, cfg.ZipReport.Webhook, metrics, z.logger)
+	jobManager.SetCallbackHandler(webhooks.Callback)
+
+	// resume stored jobs, now that the engine is ready
+	z.AbortFatal(jobManager.Resume())
+
 	// initialize Api Server
-	z.api, err = apiserver.NewApiServer(cfg.ApiServer, zptEngine, metrics, z.logger)
+	z.api, err = apiserver.NewApiServer(cfg.ApiServer, zptEngine, metrics, &apiserver.Services{
+		Version:  version,
+		Registry: reg,
+		Jobs:     jobManager,
+		Webhooks: webhooks,
+	}, z.logger)
 	z.AbortFatal(err)
 
 }
@@ -106,6 +150,12 @@ func (z *ZipReport) Start() {
 		_ = z.api.Shutdown(z.Context)
 		return nil
 	})
+	// register drain destructor; destructors run in reverse order, so running jobs are drained while the API
+	// server is still up, rejecting new jobs
+	blueprint.RegisterDestructor(func() error {
+		z.engine.Drain(z.grace)
+		return nil
+	})
 
 	z.Run(
 		func(app interface{}) error {
@@ -3,10 +3,15 @@ package internal
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"slices"
 	"zipreport-server/internal/apiserver"
+	"zipreport-server/pkg/jobs"
 	"zipreport-server/pkg/metrics"
 	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/webhook"
+	"zipreport-server/pkg/zpt"
 
 	"github.com/oddbit-project/blueprint/log"
 	"github.com/oddbit-project/blueprint/provider/httpserver"
@@ -16,16 +21,35 @@ const (
 	DefaultReadTimeoutSeconds  = 300
 	DefaultWriteTimeoutSeconds = 300
 	DefaultPort                = 6543
+	DefaultShutdownGrace       = 30 // seconds
 )
 
 type ZipReportConfig struct {
-	ReadTimeoutSeconds   int  `json:"readTimeoutSeconds"`
-	WriteTimeoutSeconds  int  `json:"writeTimeoutSeconds"`
-	EnableConsoleLogging bool `json:"enableConsoleLogging"` // Enable JS console logging, if loglevel allows
-	EnableHttpDebugging  bool `json:"enableHttpDebugging"`
-	EnableMetrics        bool `json:"enableMetrics"` // Enable Prometheus endpoint
-	Concurrency          int  `json:"concurrency"`   // Concurrent browser instances
-	BaseHttpPort         int  `json:"baseHttpPort"`  // Internal HTTP server base port
+	ReadTimeoutSeconds   int                         `json:"readTimeoutSeconds"`
+	WriteTimeoutSeconds  int                         `json:"writeTimeoutSeconds"`
+	EnableConsoleLogging bool                        `json:"enableConsoleLogging"` // Enable JS console logging, if loglevel allows
+	EnableHttpDebugging  bool                        `json:"enableHttpDebugging"`
+	EnableMetrics        bool                        `json:"enableMetrics"`        // Enable Prometheus endpoint
+	JsEventStrict        bool                        `json:"jsEventStrict"`        // Default js_event_strict value
+	IndexFallback        bool                        `json:"indexFallback"`        // Discover the index file if script is omitted
+	Concurrency          int                         `json:"concurrency"`          // Concurrent browser instances
+	BaseHttpPort         int                         `json:"baseHttpPort"`         // Internal HTTP server base port
+	QueueDepth           int                         `json:"queueDepth"`           // Max jobs waiting for a render slot; 0 is unbounded
+	QueueMaxWaitSeconds  int                         `json:"queueMaxWaitSeconds"`  // Max wait for a render slot; 0 is unbounded
+	ShutdownGraceSeconds int                         `json:"shutdownGraceSeconds"` // Max wait for running jobs on shutdown
+	ReadyRequireFreeSlot bool                        `json:"readyRequireFreeSlot"` // Readiness fails while all render slots are busy
+	PaperSizes           map[string]*PaperSizeConfig `json:"paperSizes"`           // Additional named paper sizes
+	Presets              map[string]*render.Preset   `json:"presets"`              // Named render option presets
+	TemplateDir          string                      `json:"templateDir"`          // Stored template directory; empty disables the registry
+	JobRetentionSeconds  int                         `json:"jobRetentionSeconds"`  // Asynchronous job result retention
+	JobStoreDir          string                      `json:"jobStoreDir"`          // Persistent job directory; empty keeps jobs in memory only
+	Webhook              *webhook.Config             `json:"webhook"`              // Job result callbacks
+}
+
+// PaperSizeConfig holds the dimensions of a named paper size; values accept an optional unit suffix
+type PaperSizeConfig struct {
+	Width  string `json:"width"`
+	Height string `json:"height"`
 }
 
 type Config struct {
@@ -41,8 +65,20 @@ func NewZipReportConfig() *ZipReportConfig {
 		WriteTimeoutSeconds:  DefaultWriteTimeoutSeconds,
 		EnableConsoleLogging: false,
 		EnableMetrics:        false,
+		JsEventStrict:        false,
+		IndexFallback:        false,
 		Concurrency:          render.DefaultConcurrency,
 		BaseHttpPort:         render.DefaultBasePort,
+		QueueDepth:           render.DefaultQueueDepth,
+		QueueMaxWaitSeconds:  render.DefaultQueueMaxWait,
+		ShutdownGraceSeconds: DefaultShutdownGrace,
+		ReadyRequireFreeSlot: true,
+		PaperSizes:           map[string]*PaperSizeConfig{},
+		Presets:              map[string]*render.Preset{},
+		TemplateDir:          "",
+		JobRetentionSeconds:  jobs.DefaultRetention,
+		JobStoreDir:          "",
+		Webhook:              webhook.NewConfig(),
 	}
 }
 
@@ -56,9 +92,60 @@ func (c *ZipReportConfig) Validate() error {
 	if c.Concurrency < 1 {
 		return errors.New("concurrency must be greater than zero")
 	}
+	if c.QueueDepth < 0 || c.QueueMaxWaitSeconds < 0 {
+		return errors.New("queueDepth and queueMaxWaitSeconds cannot be negative")
+	}
+	if c.ShutdownGraceSeconds < 0 {
+		return errors.New("shutdownGraceSeconds cannot be negative")
+	}
+	if c.JobRetentionSeconds < 1 {
+		return errors.New("jobRetentionSeconds must be greater than zero")
+	}
+	if c.Webhook == nil {
+		return errors.New("webhook is required")
+	}
+	if err := c.Webhook.Validate(); err != nil {
+		return err
+	}
 	if c.BaseHttpPort < 1024 {
 		return errors.New("baseHttpPort must be greater than 1024")
 	}
+	for name, size := range c.PaperSizes {
+		if len(name) == 0 || size == nil {
+			return errors.New("paperSizes entries must have a name and dimensions")
+		}
+	}
+	for name, preset := range c.Presets {
+		if len(name) == 0 || preset == nil {
+			return errors.New("presets entries must have a name and options")
+		}
+	}
+	return nil
+}
+
+// RegisterRenderOptions registers the configured job defaults, paper sizes and presets in the render package
+// Paper sizes are registered first, so presets can refer to them
+func (c *ZipReportConfig) RegisterRenderOptions() error {
+	render.SetStrictReadyDefault(c.JsEventStrict)
+	zpt.SetIndexFallback(c.IndexFallback)
+	for name, size := range c.PaperSizes {
+		width, err := render.ParseLength(size.Width)
+		if err != nil {
+			return fmt.Errorf("paper size %q: invalid width: %w", name, err)
+		}
+		height, err := render.ParseLength(size.Height)
+		if err != nil {
+			return fmt.Errorf("paper size %q: invalid height: %w", name, err)
+		}
+		if err = render.RegisterPaperSize(name, width, height); err != nil {
+			return fmt.Errorf("paper size %q: %w", name, err)
+		}
+	}
+	for name, preset := range c.Presets {
+		if err := render.RegisterPreset(name, preset); err != nil {
+			return fmt.Errorf("preset %q: %w", name, err)
+		}
+	}
 	return nil
 }
 
@@ -78,6 +165,7 @@ func apiServerConfig() *apiserver.ApiServerConfig {
 		ServerConfig:           *sc,
 		AuthTokenHeader:        "X-Auth-Key",
 		AuthTokenSecret:        "my-super-secret-token",
+		AuthTokens:             map[string]*apiserver.ApiKeyConfig{},
 		DefaultSecurityHeaders: true,
 	}
 }
@@ -94,6 +182,17 @@ func (c *Config) Validate() error {
 	if len(c.ApiServer.AuthTokenSecret) == 0 {
 		return errors.New("authTokenSecret option cannot be empty")
 	}
+	for name, key := range c.ApiServer.AuthTokens {
+		if len(name) == 0 || name == render.DefaultClient || key == nil || len(key.Secret) == 0 || key.Weight < 0 {
+			return errors.New("authTokens entries must have a name other than \"default\", a secret and a non-negative weight")
+		}
+		if key.MaxQueueDepth < 0 {
+			return errors.New("authTokens maxQueueDepth cannot be negative")
+		}
+		if len(key.MaxPriority) > 0 && !slices.Contains(render.ValidPriority, key.MaxPriority) {
+			return fmt.Errorf("invalid authTokens maxPriority %q", key.MaxPriority)
+		}
+	}
 
 	if c.ZipReport == nil {
 		return errors.New("zipreport is required")
@@ -122,10 +221,14 @@ func (c *Config) Validate() error {
 // Environment variables take precedence over config file values.
 // Supported variables:
 //   - ZIPREPORT_API_KEY: overrides apiServer.options.authTokenSecret
+//   - ZIPREPORT_WEBHOOK_SECRET: overrides zipReport.webhook.secret
 func (c *Config) ApplyEnvOverrides() {
 	if apiKey := os.Getenv("ZIPREPORT_API_KEY"); apiKey != "" {
 		c.ApiServer.AuthTokenSecret = apiKey
 	}
+	if secret := os.Getenv("ZIPREPORT_WEBHOOK_SECRET"); secret != "" && c.ZipReport != nil && c.ZipReport.Webhook != nil {
+		c.ZipReport.Webhook.Secret = secret
+	}
 }
 
 func (c *Config) DumpDefaults() (string, error) {
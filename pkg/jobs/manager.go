@@ -0,0 +1,460 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"zipreport-server/pkg/monitor"
+	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/zpt"
+
+	"github.com/oddbit-project/blueprint/log"
+)
+
+// Job status
+const StatusQueued = "queued"
+const StatusRunning = "running"
+const StatusDone = "done"
+const StatusFailed = "failed"
+
+const DefaultRetention = 3600 // seconds
+
+// cleanup interval bounds
+const minCleanupInterval = time.Second
+const maxCleanupInterval = time.Minute
+
+var ErrNotFound = errors.New("job not found")
+var ErrDuplicateId = errors.New("job id already exists")
+
+// Info is the status of an asynchronous job
+type Info struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	QueueTime   float64    `json:"queueTime"`   // seconds waiting for a render slot
+	ElapsedTime float64    `json:"elapsedTime"` // render time, in seconds
+	ContentType string     `json:"contentType,omitempty"`
+	Size        int        `json:"size,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Code        string     `json:"code,omitempty"`   // error code of failed jobs
+	Client      string     `json:"client,omitempty"` // submitting client; jobs are only visible to their client
+}
+
+// CallbackHandler returns the function delivering a job result to callbackURL
+type CallbackHandler func(callbackURL string, job *render.Job) func(*Info, *render.JobResult)
+
+// entry is a job tracked by the manager
+type entry struct {
+	info        Info
+	job         *render.Job
+	result      *render.JobResult // nil until finished; stored results are loaded on demand
+	stored      *Record           // finished job whose result is read from the store on demand
+	callbackURL string
+	release     func() // releases job resources, such as the spooled upload
+}
+
+// Manager runs render jobs in the background, and keeps their results until they expire
+type Manager struct {
+	mx        sync.Mutex
+	ctx       context.Context
+	engine    *render.Engine
+	metrics   *monitor.Metrics
+	retention time.Duration
+	store     Store
+	callbacks CallbackHandler
+	jobs      map[string]*entry
+	wg        sync.WaitGroup
+	logger    *log.Logger
+}
+
+// NewManager creates a job manager; expired results are removed until ctx is done
+// If store is nil, jobs are kept in memory only
+func NewManager(ctx context.Context, engine *render.Engine, m *monitor.Metrics, retention time.Duration, store Store, logger *log.Logger) *Manager {
+	if logger == nil {
+		logger = log.New("zipreport-jobs")
+	}
+	if retention <= 0 {
+		retention = DefaultRetention * time.Second
+	}
+	mgr := &Manager{
+		ctx:       ctx,
+		engine:    engine,
+		metrics:   m,
+		retention: retention,
+		store:     store,
+		jobs:      make(map[string]*entry),
+		logger:    logger,
+	}
+	go mgr.cleanupLoop()
+	return mgr
+}
+
+// SetCallbackHandler sets the handler used to deliver results of jobs with a callback url
+func (m *Manager) SetCallbackHandler(h CallbackHandler) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.callbacks = h
+}
+
+// Submit queues a render job; payload is the spooled ZPT file read by job.Zpt, and is removed once
+// the job is finished. If callbackURL is not empty, the result is delivered by the callback handler
+// Fails with a render.QueueError if the render queue is full, or ErrDuplicateId if the job id is in use
+func (m *Manager) Submit(job *render.Job, payload *os.File, callbackURL string) (*Info, error) {
+	release := removeFile(payload)
+	ticket, err := m.engine.Queue.ReserveBackground(job.Client, false)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	job.Ticket = ticket
+	e := &entry{
+		info: Info{
+			Id:        job.Id.String(),
+			Status:    StatusQueued,
+			CreatedAt: time.Now().UTC(),
+			Client:    job.Client,
+		},
+		job:         job,
+		callbackURL: callbackURL,
+		release:     release,
+	}
+
+	// reserve the id before storing the job, so an existing job and its stored files are never replaced
+	m.mx.Lock()
+	if _, exists := m.jobs[e.info.Id]; exists {
+		m.mx.Unlock()
+		ticket.Release()
+		release()
+		return nil, ErrDuplicateId
+	}
+	m.jobs[e.info.Id] = e
+	m.mx.Unlock()
+
+	if m.store != nil {
+		stat, err := payload.Stat()
+		if err == nil {
+			err = m.store.Create(e.record(), io.NewSectionReader(payload, 0, stat.Size()))
+		}
+		if err != nil {
+			m.mx.Lock()
+			delete(m.jobs, e.info.Id)
+			m.mx.Unlock()
+			ticket.Release()
+			release()
+			return nil, err
+		}
+	}
+	m.metrics.TotalOps.Inc()
+	m.logger.Info("job queued", log.KV{"id": e.info.Id})
+	return m.start(e), nil
+}
+
+// Resume loads the stored jobs; finished jobs are kept until they expire, and unfinished jobs
+// are queued again. Must be called once the engine is ready
+func (m *Manager) Resume() error {
+	if m.store == nil {
+		return nil
+	}
+	records, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+	resumed := 0
+	for _, rec := range records {
+		id := rec.Info.Id
+		if rec.Info.FinishedAt != nil {
+			m.mx.Lock()
+			m.jobs[id] = &entry{info: rec.Info, job: rec.Job, stored: rec}
+			m.mx.Unlock()
+			continue
+		}
+		payload, err := m.store.Payload(id)
+		if err == nil {
+			var stat os.FileInfo
+			if stat, err = payload.Stat(); err == nil {
+				rec.Job.Zpt, err = zpt.NewZptReader(payload, stat.Size())
+			}
+			if err != nil {
+				_ = payload.Close()
+			}
+		}
+		if err != nil {
+			m.logger.Error(err, "could not resume stored job, removing", log.KV{"id": id})
+			_ = m.store.Delete(id)
+			continue
+		}
+		// jobs accepted before the restart are queued regardless of the queue depth
+		if rec.Job.Ticket, err = m.engine.Queue.ReserveBackground(rec.Job.Client, true); err != nil {
+			// the stored job is left unfinished, and resumed on the next start
+			m.logger.Error(err, "could not queue stored job", log.KV{"id": id})
+			_ = payload.Close()
+			continue
+		}
+		info := rec.Info
+		info.Status = StatusQueued
+		info.StartedAt = nil
+		m.start(&entry{
+			info:        info,
+			job:         rec.Job,
+			callbackURL: rec.CallbackURL,
+			release:     func() { _ = payload.Close() },
+		})
+		resumed++
+	}
+	m.logger.Info("stored jobs loaded", log.KV{"count": len(records), "resumed": resumed})
+	return nil
+}
+
+// start tracks the job and runs it in the background
+func (m *Manager) start(e *entry) *Info {
+	job := e.job
+	job.OnStart = func() {
+		m.mx.Lock()
+		defer m.mx.Unlock()
+		now := time.Now().UTC()
+		e.info.Status = StatusRunning
+		e.info.StartedAt = &now
+		e.info.QueueTime = now.Sub(e.info.CreatedAt).Seconds()
+	}
+
+	m.mx.Lock()
+	m.jobs[e.info.Id] = e
+	info := e.info
+	var done func(*Info, *render.JobResult)
+	if len(e.callbackURL) > 0 && m.callbacks != nil {
+		done = m.callbacks(e.callbackURL, job)
+	}
+	m.mx.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run(e, done)
+	}()
+	return &info
+}
+
+// run renders the job and records the result
+func (m *Manager) run(e *entry, done func(*Info, *render.JobResult)) {
+	result := m.engine.RenderJob(e.job)
+	if e.release != nil {
+		e.release()
+	}
+	if m.store != nil && errors.Is(result.Error, render.ErrShuttingDown) {
+		// the stored job is left unfinished, and resumed on the next start
+		m.logger.Warn("job interrupted by shutdown", log.KV{"id": e.info.Id})
+		return
+	}
+
+	m.mx.Lock()
+	now := time.Now().UTC()
+	expires := now.Add(m.retention)
+	e.result = result
+	e.info.FinishedAt = &now
+	e.info.ExpiresAt = &expires
+	e.info.ElapsedTime = result.ElapsedTime
+	if result.Success {
+		e.info.Status = StatusDone
+		e.info.ContentType = e.job.ContentType()
+		e.info.Size = len(result.Output)
+	} else {
+		e.info.Status = StatusFailed
+		if result.Error != nil {
+			e.info.Code = render.ErrorCode(result.Error)
+			e.info.Error = render.ErrorMessage(result.Error)
+		}
+	}
+	info := e.info
+	rec := e.record()
+	m.mx.Unlock()
+
+	if m.store != nil {
+		var output []byte
+		if result.Success {
+			output = result.Output
+		}
+		if err := m.store.Finish(rec, output); err != nil {
+			m.logger.Error(err, "could not store job result", log.KV{"id": info.Id})
+		} else if result.Success {
+			// the output is read from the store, instead of being kept in memory until the job expires
+			m.mx.Lock()
+			e.result, e.stored = nil, rec
+			m.mx.Unlock()
+		}
+	}
+
+	if result.Success {
+		m.metrics.SuccessOps.Inc()
+		m.metrics.ConversionTime.Observe(result.ElapsedTime)
+		m.logger.Info("job finished", log.KV{"id": info.Id, "elapsedTime": info.ElapsedTime})
+	} else {
+		m.metrics.FailedOps.Inc()
+		m.logger.Error(result.Error, "job failed", log.KV{"id": info.Id})
+	}
+	if done != nil {
+		done(&info, result)
+	}
+}
+
+// ownedBy returns true if the job was submitted by client; jobs stored without a client belong to the
+// default client
+func (e *entry) ownedBy(client string) bool {
+	owner := e.info.Client
+	if len(owner) == 0 {
+		owner = render.DefaultClient
+	}
+	return owner == client
+}
+
+// record returns the persisted state of the entry; must be called with the lock held, or before the job starts
+func (e *entry) record() *Record {
+	rec := &Record{
+		Info:        e.info,
+		Job:         e.job,
+		CallbackURL: e.callbackURL,
+	}
+	if e.result != nil && e.result.Error != nil {
+		errors.As(e.result.Error, &rec.TemplateError)
+		rec.ReadyTimeout = errors.Is(e.result.Error, render.ErrReadyTimeout)
+	}
+	return rec
+}
+
+// storedResult rebuilds the result of a finished job loaded from the store
+func (m *Manager) storedResult(rec *Record) (*render.JobResult, error) {
+	result := &render.JobResult{
+		ElapsedTime: rec.Info.ElapsedTime,
+		Success:     rec.Info.Status == StatusDone,
+	}
+	if result.Success {
+		output, err := m.store.Output(rec.Info.Id)
+		if err != nil {
+			return nil, err
+		}
+		result.Output = output
+		return result, nil
+	}
+	switch {
+	case rec.TemplateError != nil:
+		result.Error = rec.TemplateError
+	case rec.ReadyTimeout:
+		result.Error = render.ErrReadyTimeout
+	default:
+		result.Error = render.ErrorFromCode(rec.Info.Code, rec.Info.Error)
+	}
+	return result, nil
+}
+
+// removeFile returns a function closing and removing f
+func removeFile(f *os.File) func() {
+	return func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+}
+
+// Get returns the status of a job
+func (m *Manager) Get(id string, client string) (*Info, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	e, ok := m.jobs[id]
+	if !ok || !e.ownedBy(client) {
+		return nil, ErrNotFound
+	}
+	info := e.info
+	return &info, nil
+}
+
+// Result returns the status, render job and result of a job; the result is nil if the job is not finished
+func (m *Manager) Result(id string, client string) (*Info, *render.Job, *render.JobResult, error) {
+	m.mx.Lock()
+	e, ok := m.jobs[id]
+	if !ok || !e.ownedBy(client) {
+		m.mx.Unlock()
+		return nil, nil, nil, ErrNotFound
+	}
+	info, job, result, stored := e.info, e.job, e.result, e.stored
+	m.mx.Unlock()
+
+	if stored != nil {
+		// not cached, so the output of stored jobs is only held in memory while it is sent
+		var err error
+		if result, err = m.storedResult(stored); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return &info, job, result, nil
+}
+
+// List returns the status of all jobs, oldest first
+func (m *Manager) List() []*Info {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	result := make([]*Info, 0, len(m.jobs))
+	for _, e := range m.jobs {
+		info := e.info
+		result = append(result, &info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Cleanup removes finished jobs whose retention period expired; returns the number of removed jobs
+func (m *Manager) Cleanup() int {
+	m.mx.Lock()
+	now := time.Now()
+	expired := make([]string, 0)
+	for id, e := range m.jobs {
+		if e.info.ExpiresAt != nil && now.After(*e.info.ExpiresAt) {
+			delete(m.jobs, id)
+			expired = append(expired, id)
+		}
+	}
+	m.mx.Unlock()
+
+	if m.store != nil {
+		for _, id := range expired {
+			if err := m.store.Delete(id); err != nil {
+				m.logger.Warn("could not remove stored job", log.KV{"id": id, "error": err.Error()})
+			}
+		}
+	}
+	if len(expired) > 0 {
+		m.logger.Debug("expired jobs removed", log.KV{"count": len(expired)})
+	}
+	return len(expired)
+}
+
+func (m *Manager) cleanupLoop() {
+	interval := m.retention / 10
+	if interval < minCleanupInterval {
+		interval = minCleanupInterval
+	}
+	if interval > maxCleanupInterval {
+		interval = maxCleanupInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Cleanup()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Wait blocks until all submitted jobs are finished
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"zipreport-server/pkg/render"
+
+	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
+)
+
+// FileStore file names, per job directory
+const recordFile = "job.json"
+const payloadFile = "payload.zpt"
+const outputFile = "output"
+
+// Record is the persisted state of a job
+type Record struct {
+	Info          Info                  `json:"info"`
+	Job           *render.Job           `json:"job"`
+	CallbackURL   string                `json:"callbackUrl,omitempty"`
+	TemplateError *render.TemplateError `json:"templateError,omitempty"` // failure signalled by the template
+	ReadyTimeout  bool                  `json:"readyTimeout,omitempty"`  // failed because the page was not ready in time
+}
+
+// Store persists jobs, so they survive restarts
+type Store interface {
+	// Create persists a new job and its ZPT payload
+	Create(rec *Record, payload io.Reader) error
+	// Finish persists a finished job and its output; the payload is no longer needed
+	Finish(rec *Record, output []byte) error
+	// Load returns all stored jobs
+	Load() ([]*Record, error)
+	// Payload opens the ZPT payload of an unfinished job
+	Payload(id string) (*os.File, error)
+	// Output returns the output of a finished job
+	Output(id string) ([]byte, error)
+	// Delete removes a job
+	Delete(id string) error
+}
+
+// FileStore is a Store keeping each job in its own directory: <dir>/<id>/{job.json, payload.zpt, output}
+type FileStore struct {
+	dir    string
+	logger *log.Logger
+}
+
+// NewFileStore creates a file store in dir; the directory is created if it doesn't exist
+func NewFileStore(dir string, logger *log.Logger) (*FileStore, error) {
+	if logger == nil {
+		logger = log.New("zipreport-jobstore")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, logger: logger}, nil
+}
+
+// path returns the path of a job file; ids are validated so they can't escape the store directory
+func (s *FileStore) path(id string, name string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, id, name), nil
+}
+
+func (s *FileStore) Create(rec *Record, payload io.Reader) error {
+	path, err := s.path(rec.Info.Id, payloadFile)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err = writeFile(path, func(w io.Writer) error {
+		_, err := io.Copy(w, payload)
+		return err
+	}); err != nil {
+		_ = s.Delete(rec.Info.Id)
+		return err
+	}
+	if err = s.writeRecord(rec); err != nil {
+		_ = s.Delete(rec.Info.Id)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Finish(rec *Record, output []byte) error {
+	if len(output) > 0 {
+		path, err := s.path(rec.Info.Id, outputFile)
+		if err != nil {
+			return err
+		}
+		if err = writeFile(path, func(w io.Writer) error {
+			_, err := w.Write(output)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	// the record is written last, so a job is only finished once its output is stored
+	if err := s.writeRecord(rec); err != nil {
+		return err
+	}
+	path, _ := s.path(rec.Info.Id, payloadFile)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Warn("could not remove job payload", log.KV{"id": rec.Info.Id, "error": err.Error()})
+	}
+	return nil
+}
+
+// Load reads all job records; invalid entries are logged and skipped
+func (s *FileStore) Load() ([]*Record, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Record, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path, err := s.path(entry.Name(), recordFile)
+		if err != nil {
+			continue
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			// incomplete job, interrupted while being created
+			s.logger.Warn("removing incomplete stored job", log.KV{"id": entry.Name(), "error": err.Error()})
+			_ = s.Delete(entry.Name())
+			continue
+		}
+		rec := &Record{}
+		if err = json.Unmarshal(buf, rec); err != nil || rec.Job == nil || rec.Info.Id != entry.Name() {
+			s.logger.Warn("skipping invalid stored job", log.KV{"id": entry.Name()})
+			continue
+		}
+		result = append(result, rec)
+	}
+	return result, nil
+}
+
+func (s *FileStore) Payload(id string) (*os.File, error) {
+	path, err := s.path(id, payloadFile)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *FileStore) Output(id string) ([]byte, error) {
+	path, err := s.path(id, outputFile)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id, "")
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+func (s *FileStore) writeRecord(rec *Record) error {
+	path, err := s.path(rec.Info.Id, recordFile)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// writeFile writes a file atomically, via a temporary file in the same directory
+func writeFile(path string, fn func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if err = fn(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
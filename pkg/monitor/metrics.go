@@ -6,12 +6,22 @@ import (
 )
 
 type Metrics struct {
-	HttpServers    prometheus.Gauge
-	Browsers       prometheus.Gauge
-	TotalOps       prometheus.Counter
-	SuccessOps     prometheus.Counter
-	FailedOps      prometheus.Counter
-	ConversionTime prometheus.Histogram
+	HttpServers        prometheus.Gauge
+	Browsers           prometheus.Gauge
+	TotalOps           prometheus.Counter
+	SuccessOps         prometheus.Counter
+	FailedOps          prometheus.Counter
+	CanceledOps        prometheus.Counter
+	ReadyTimeouts      prometheus.Counter
+	WebhookAttempts    prometheus.Counter
+	WebhookFailures    prometheus.Counter
+	QueueDepth         prometheus.Gauge
+	QueueRejected      prometheus.Counter
+	QueueWaitTime      prometheus.Histogram
+	PriorityQueueDepth *prometheus.GaugeVec     // by priority class
+	PriorityWaitTime   *prometheus.HistogramVec // by priority class
+	PriorityJobs       *prometheus.CounterVec   // by priority class
+	ConversionTime     prometheus.Histogram
 }
 
 func NewMetrics() *Metrics {
@@ -36,6 +46,48 @@ func NewMetrics() *Metrics {
 			Name: "total_request_error",
 			Help: "Total failed conversion requests",
 		}),
+		CanceledOps: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "total_request_canceled",
+			Help: "Total conversion requests canceled by the client",
+		}),
+		ReadyTimeouts: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "total_ready_timeouts",
+			Help: "Total jobs where the readiness conditions timed out",
+		}),
+		WebhookAttempts: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "total_webhook_attempts",
+			Help: "Total result callback delivery attempts",
+		}),
+		WebhookFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "total_webhook_failures",
+			Help: "Total result callbacks not delivered after all retries",
+		}),
+		QueueDepth: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "current_queue_depth",
+			Help: "Current number of jobs waiting for a render slot",
+		}),
+		QueueRejected: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "total_queue_rejected",
+			Help: "Total jobs rejected because the render queue was full, or the max queue wait was exceeded",
+		}),
+		QueueWaitTime: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "queue_wait_time",
+			Help:    "Time spent waiting for a render slot, in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+		PriorityQueueDepth: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "current_priority_queue_depth",
+			Help: "Current number of jobs waiting for a render slot, per priority class",
+		}, []string{"priority"}),
+		PriorityWaitTime: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "priority_queue_wait_time",
+			Help:    "Time spent waiting for a render slot per priority class, in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"priority"}),
+		PriorityJobs: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "total_priority_jobs",
+			Help: "Total jobs started, per priority class",
+		}, []string{"priority"}),
 		ConversionTime: promauto.NewHistogram(prometheus.HistogramOpts{
 			Name: "conversion_time",
 			Help: "PDF conversion time, in seconds.",
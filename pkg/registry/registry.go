@@ -0,0 +1,416 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"zipreport-server/pkg/zpt"
+
+	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
+)
+
+// MaxNameLength caps the length of template names
+const MaxNameLength = 256
+
+const metadataFile = "template.json"
+
+var ErrNotFound = errors.New("template not found")
+var ErrInvalidName = errors.New("invalid template name")
+
+// Template is a stored ZPT, with one or more versions
+type Template struct {
+	Id          string     `json:"id"`
+	Name        string     `json:"name"`
+	Versions    []*Version `json:"versions"`
+	NextVersion int        `json:"nextVersion,omitempty"` // stored only; numbers of deleted versions are not reused
+}
+
+// Version is an uploaded revision of a template
+type Version struct {
+	Version   int       `json:"version"`
+	Size      int64     `json:"size"`
+	Sha256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// the zpt file is kept open while the version is in use, so jobs don't re-read the upload
+	file    *os.File
+	reader  *zpt.ZptReader
+	refs    int
+	deleted bool
+}
+
+// Registry stores templates in a directory, as <dir>/<id>/<version>.zpt plus a template.json metadata file
+// Templates are verified when uploaded and loaded, and kept open for rendering
+type Registry struct {
+	mx        sync.Mutex
+	writeMx   sync.Mutex // serializes uploads and deletions
+	dir       string
+	templates map[string]*Template
+	logger    *log.Logger
+}
+
+// NewRegistry creates the registry directory if needed, and loads existing templates
+// Versions that can't be opened as a ZPT are skipped
+func NewRegistry(dir string, logger *log.Logger) (*Registry, error) {
+	if logger == nil {
+		logger = log.New("zipreport-registry")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	r := &Registry{
+		dir:       dir,
+		templates: make(map[string]*Template),
+		logger:    logger,
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := uuid.Parse(entry.Name()); err != nil {
+			continue
+		}
+		if err = r.load(entry.Name()); err != nil {
+			r.logger.Error(err, "failed to load template", log.KV{"id": entry.Name()})
+		}
+	}
+	r.logger.Info("template registry loaded", log.KV{"dir": dir, "templates": len(r.templates)})
+	return r, nil
+}
+
+// load reads a template from disk and opens its versions
+func (r *Registry) load(id string) error {
+	buf, err := os.ReadFile(filepath.Join(r.dir, id, metadataFile))
+	if err != nil {
+		return err
+	}
+	tpl := &Template{}
+	if err = json.Unmarshal(buf, tpl); err != nil {
+		return err
+	}
+	tpl.Id = id
+	versions := make([]*Version, 0, len(tpl.Versions))
+	for _, v := range tpl.Versions {
+		// metadata written before nextVersion was stored
+		tpl.NextVersion = max(tpl.NextVersion, v.Version+1)
+		if err = v.open(r.versionPath(id, v.Version)); err != nil {
+			r.logger.Error(err, "failed to open template version", log.KV{"id": id, "version": v.Version})
+			continue
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("template %s has no valid versions", id)
+	}
+	tpl.Versions = versions
+	r.templates[id] = tpl
+	return nil
+}
+
+// open opens and verifies the zpt file of a version
+func (v *Version) open(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	reader, err := zpt.NewZptReader(f, stat.Size())
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	v.file, v.reader, v.Size = f, reader, stat.Size()
+	return nil
+}
+
+// release closes the version file, if it is deleted and no longer in use; must be called with the lock held
+func (v *Version) release() {
+	if v.deleted && v.refs == 0 && v.file != nil {
+		_ = v.file.Close()
+		v.file, v.reader = nil, nil
+	}
+}
+
+func (r *Registry) versionPath(id string, version int) string {
+	return filepath.Join(r.dir, id, strconv.Itoa(version)+".zpt")
+}
+
+// Create stores a new template, as version 1
+func (r *Registry) Create(name string, src io.Reader) (*Template, error) {
+	if len(name) > MaxNameLength {
+		return nil, ErrInvalidName
+	}
+	r.writeMx.Lock()
+	defer r.writeMx.Unlock()
+
+	id := uuid.New().String()
+	if err := os.MkdirAll(filepath.Join(r.dir, id), 0o750); err != nil {
+		return nil, err
+	}
+	v, err := r.store(id, 1, src)
+	if err != nil {
+		_ = os.RemoveAll(filepath.Join(r.dir, id))
+		return nil, err
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	tpl := &Template{Id: id, Name: name, Versions: []*Version{v}, NextVersion: 2}
+	if err = r.saveMetadata(tpl); err != nil {
+		_ = v.file.Close()
+		_ = os.RemoveAll(filepath.Join(r.dir, id))
+		return nil, err
+	}
+	r.templates[id] = tpl
+	r.logger.Info("template created", log.KV{"id": id, "name": name, "size": v.Size})
+	return tpl.copy(), nil
+}
+
+// AddVersion stores a new version of an existing template; version numbers always increase, even if the latest
+// version was deleted
+func (r *Registry) AddVersion(id string, src io.Reader) (*Template, error) {
+	r.writeMx.Lock()
+	defer r.writeMx.Unlock()
+
+	r.mx.Lock()
+	tpl, ok := r.templates[id]
+	if !ok {
+		r.mx.Unlock()
+		return nil, ErrNotFound
+	}
+	next := tpl.NextVersion
+	r.mx.Unlock()
+
+	v, err := r.store(id, next, src)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	tpl.Versions = append(tpl.Versions, v)
+	tpl.NextVersion = next + 1
+	if err = r.saveMetadata(tpl); err != nil {
+		tpl.Versions = tpl.Versions[:len(tpl.Versions)-1]
+		tpl.NextVersion = next
+		_ = v.file.Close()
+		_ = os.Remove(r.versionPath(id, next))
+		return nil, err
+	}
+	r.logger.Info("template version added", log.KV{"id": id, "version": next, "size": v.Size})
+	return tpl.copy(), nil
+}
+
+// store writes the upload to a temporary file, verifies it is a valid ZPT and moves it to the version path
+func (r *Registry) store(id string, version int, src io.Reader) (*Version, error) {
+	tmp, err := os.CreateTemp(filepath.Join(r.dir, id), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if err == nil {
+		_, err = zpt.NewZptReader(tmp, size)
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.versionPath(id, version))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	v := &Version{
+		Version:   version,
+		Sha256:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err = v.open(r.versionPath(id, version)); err != nil {
+		_ = os.Remove(r.versionPath(id, version))
+		return nil, err
+	}
+	return v, nil
+}
+
+// saveMetadata atomically writes the template metadata; must be called with the lock held
+func (r *Registry) saveMetadata(tpl *Template) error {
+	buf, err := json.MarshalIndent(tpl, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, tpl.Id, metadataFile)
+	if err = os.WriteFile(path+".tmp", buf, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// List returns all templates, sorted by name and id
+func (r *Registry) List() []*Template {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	result := make([]*Template, 0, len(r.templates))
+	for _, tpl := range r.templates {
+		result = append(result, tpl.copy())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+// Get returns a template
+func (r *Registry) Get(id string) (*Template, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	tpl, ok := r.templates[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return tpl.copy(), nil
+}
+
+// Delete removes a template and all its versions
+// Versions in use by running jobs are closed when released
+func (r *Registry) Delete(id string) error {
+	r.writeMx.Lock()
+	defer r.writeMx.Unlock()
+	return r.delete(id)
+}
+
+func (r *Registry) delete(id string) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	tpl, ok := r.templates[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.templates, id)
+	for _, v := range tpl.Versions {
+		v.deleted = true
+		v.release()
+	}
+	r.logger.Info("template deleted", log.KV{"id": id})
+	return os.RemoveAll(filepath.Join(r.dir, id))
+}
+
+// DeleteVersion removes a template version; removing the last version removes the template
+func (r *Registry) DeleteVersion(id string, version int) error {
+	r.writeMx.Lock()
+	defer r.writeMx.Unlock()
+
+	r.mx.Lock()
+	tpl, ok := r.templates[id]
+	if !ok {
+		r.mx.Unlock()
+		return ErrNotFound
+	}
+	idx := tpl.versionIndex(version)
+	if idx < 0 {
+		r.mx.Unlock()
+		return ErrNotFound
+	}
+	if len(tpl.Versions) == 1 {
+		r.mx.Unlock()
+		return r.delete(id)
+	}
+	defer r.mx.Unlock()
+	v := tpl.Versions[idx]
+	tpl.Versions = append(tpl.Versions[:idx:idx], tpl.Versions[idx+1:]...)
+	if err := r.saveMetadata(tpl); err != nil {
+		return err
+	}
+	v.deleted = true
+	v.release()
+	r.logger.Info("template version deleted", log.KV{"id": id, "version": version})
+	return os.Remove(r.versionPath(id, version))
+}
+
+// Acquire returns the reader of a template version, or of the latest version if version is 0
+// The returned function must be called when the reader is no longer in use
+func (r *Registry) Acquire(id string, version int) (*zpt.ZptReader, func(), error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	tpl, ok := r.templates[id]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	idx := len(tpl.Versions) - 1
+	if version != 0 {
+		if idx = tpl.versionIndex(version); idx < 0 {
+			return nil, nil, ErrNotFound
+		}
+	}
+	v := tpl.Versions[idx]
+	v.refs++
+	var once sync.Once
+	return v.reader, func() {
+		once.Do(func() {
+			r.mx.Lock()
+			defer r.mx.Unlock()
+			v.refs--
+			v.release()
+		})
+	}, nil
+}
+
+// Close closes all template files; the registry must not be used afterwards
+func (r *Registry) Close() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, tpl := range r.templates {
+		for _, v := range tpl.Versions {
+			if v.file != nil {
+				_ = v.file.Close()
+			}
+		}
+	}
+	r.templates = map[string]*Template{}
+}
+
+func (t *Template) versionIndex(version int) int {
+	for i, v := range t.Versions {
+		if v.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// copy returns a copy of the template metadata; must be called with the lock held
+func (t *Template) copy() *Template {
+	result := &Template{Id: t.Id, Name: t.Name, Versions: make([]*Version, 0, len(t.Versions))}
+	for _, v := range t.Versions {
+		result.Versions = append(result.Versions, &Version{
+			Version:   v.Version,
+			Size:      v.Size,
+			Sha256:    v.Sha256,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+	return result
+}
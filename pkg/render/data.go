@@ -0,0 +1,31 @@
+package render
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// MaxDataSize caps the size of the per-request JSON data
+const MaxDataSize = 8 << 20 // 8 MiB
+
+var ErrInvalidData = errors.New("invalid data, expected a JSON document")
+var ErrDataTooLarge = errors.New("data exceeds maximum size")
+
+// SetData validates and sets the JSON data exposed to the page as window.zptData
+func (r *Job) SetData(data []byte) error {
+	if len(data) > MaxDataSize {
+		return ErrDataTooLarge
+	}
+	if !json.Valid(data) {
+		return ErrInvalidData
+	}
+	r.Data = data
+	return nil
+}
+
+// dataScript returns the script that defines window.zptData, evaluated before any page script
+// The data is parsed with JSON.parse, so keys such as __proto__ are kept as regular properties
+func (r *Job) dataScript() string {
+	src, _ := json.Marshal(string(r.Data))
+	return "window.zptData = JSON.parse(" + string(src) + ");"
+}
@@ -0,0 +1,148 @@
+package render
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Upper bounds for collected diagnostics, per job
+const DiagnosticsMaxEntries = 200     // per category
+const DiagnosticsMaxMessage = 4 << 10 // 4 KiB
+
+// ConsoleEntry is a JS console call
+type ConsoleEntry struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// ExceptionEntry is an uncaught JS exception
+type ExceptionEntry struct {
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+// LogEntry is a browser log entry, such as network or security errors
+type LogEntry struct {
+	Level   string `json:"level"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Diagnostics collects page output for a job, to be returned to the caller
+type Diagnostics struct {
+	mx         sync.Mutex
+	Console    []ConsoleEntry   `json:"console"`
+	Exceptions []ExceptionEntry `json:"exceptions"`
+	Log        []LogEntry       `json:"log"`
+	NotFound   []string         `json:"notFound"` // ZPT files requested but not found
+	Truncated  bool             `json:"truncated"`
+}
+
+func NewDiagnostics() *Diagnostics {
+	return &Diagnostics{
+		Console:    []ConsoleEntry{},
+		Exceptions: []ExceptionEntry{},
+		Log:        []LogEntry{},
+		NotFound:   []string{},
+	}
+}
+
+// full checks the entry limit; must be called with the lock held
+func (d *Diagnostics) full(count int) bool {
+	if count >= DiagnosticsMaxEntries {
+		d.Truncated = true
+		return true
+	}
+	return false
+}
+
+func (d *Diagnostics) AddConsole(level string, parts []string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.full(len(d.Console)) {
+		return
+	}
+	d.Console = append(d.Console, ConsoleEntry{Level: level, Message: d.truncate(strings.Join(parts, " "))})
+}
+
+func (d *Diagnostics) AddException(details *proto.RuntimeExceptionDetails) {
+	if details == nil {
+		return
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.full(len(d.Exceptions)) {
+		return
+	}
+	msg := details.Text
+	if details.Exception != nil && len(details.Exception.Description) > 0 {
+		msg = details.Exception.Description
+	}
+	d.Exceptions = append(d.Exceptions, ExceptionEntry{
+		Message: d.truncate(msg),
+		URL:     details.URL,
+		Line:    details.LineNumber,
+		Column:  details.ColumnNumber,
+	})
+}
+
+func (d *Diagnostics) AddLog(entry *proto.LogLogEntry) {
+	if entry == nil {
+		return
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.full(len(d.Log)) {
+		return
+	}
+	d.Log = append(d.Log, LogEntry{
+		Level:   string(entry.Level),
+		Source:  string(entry.Source),
+		Message: d.truncate(entry.Text),
+		URL:     entry.URL,
+	})
+}
+
+// AddNotFound records a ZPT file that was requested by the page but doesn't exist
+func (d *Diagnostics) AddNotFound(name string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.full(len(d.NotFound)) {
+		return
+	}
+	d.NotFound = append(d.NotFound, d.truncate(name))
+}
+
+// Count returns the total number of collected entries
+func (d *Diagnostics) Count() int {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return len(d.Console) + len(d.Exceptions) + len(d.Log) + len(d.NotFound)
+}
+
+func (d *Diagnostics) MarshalJSON() ([]byte, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return json.Marshal(struct {
+		Console    []ConsoleEntry   `json:"console"`
+		Exceptions []ExceptionEntry `json:"exceptions"`
+		Log        []LogEntry       `json:"log"`
+		NotFound   []string         `json:"notFound"`
+		Truncated  bool             `json:"truncated"`
+	}{d.Console, d.Exceptions, d.Log, d.NotFound, d.Truncated})
+}
+
+// truncate limits message size; must be called with the lock held
+func (d *Diagnostics) truncate(s string) string {
+	if len(s) > DiagnosticsMaxMessage {
+		d.Truncated = true
+		return strings.ToValidUTF8(s[:DiagnosticsMaxMessage], "")
+	}
+	return s
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -23,16 +22,34 @@ import (
 const DefaultConcurrency = 8
 const DefaultBasePort = 42000
 
+// DrainCancelTimeout bounds the wait for canceled jobs to finish, once the drain grace period expires
+const DrainCancelTimeout = 10 * time.Second
+
+// ErrJobCanceled is the error of jobs canceled by the caller, e.g. when the client disconnects
+var ErrJobCanceled = errors.New("render job canceled")
+
+// ErrShuttingDown is the error of jobs rejected while draining, or canceled once the drain grace period expires
+var ErrShuttingDown = errors.New("server is shutting down")
+
 type Engine struct {
-	ServerPool     *zpt.ServerPool
-	BrowserPool    rod.Pool[rod.Browser]
-	metrics        *monitor.Metrics
-	logger         *log.Logger
-	httpDebug      bool
-	consoleLogging bool
-	launcherURL    string          // Shared launcher URL for no-sandbox mode
-	launcherMx     sync.Mutex      // Guards launcherURL during relaunch
-	ctx            context.Context // Application-level context for pooled browsers
+	Queue            *Queue
+	ServerPool       *zpt.ServerPool
+	BrowserPool      rod.Pool[rod.Browser]
+	metrics          *monitor.Metrics
+	logger           *log.Logger
+	httpDebug        bool
+	consoleLogging   bool
+	launcherURL      string          // Shared launcher URL for no-sandbox mode
+	launcherMx       sync.Mutex      // Guards launcherURL during relaunch
+	ctx              context.Context // Application-level context for pooled browsers
+	jobsCtx          context.Context // Parent context of jobs, canceled with ErrShuttingDown when draining times out
+	cancelJobs       context.CancelCauseFunc
+	jobsMx           sync.Mutex // Guards draining and running
+	draining         bool
+	running          int           // jobs in RenderJob, waiting for a slot or rendering
+	drained          chan struct{} // closed once draining and no jobs are running
+	health           browserHealth // last browser check, for readiness probes
+	readyIgnoreSlots atomic.Bool   // readiness doesn't require a free render slot
 }
 
 func NewEngine(ctx context.Context, concurrency int, basePort int, m *monitor.Metrics, logger *log.Logger) *Engine {
@@ -53,13 +70,18 @@ func NewEngine(ctx context.Context, concurrency int, basePort int, m *monitor.Me
 		launcherURL = l.MustLaunch()
 	}
 
+	jobsCtx, cancelJobs := context.WithCancelCause(ctx)
 	return &Engine{
+		Queue:       NewQueue(concurrency, m),
 		ServerPool:  zpt.NewServerPoolWithContext(ctx, concurrency, basePort, m, logger),
 		BrowserPool: rod.NewBrowserPool(concurrency),
 		metrics:     m,
 		logger:      logger,
 		launcherURL: launcherURL,
 		ctx:         ctx,
+		jobsCtx:     jobsCtx,
+		cancelJobs:  cancelJobs,
+		drained:     make(chan struct{}),
 	}
 }
 
@@ -71,9 +93,43 @@ func (e *Engine) EnableHttpDebugging() {
 	e.httpDebug = true
 }
 
-func (e *Engine) RenderJob(job *Job) *JobResult {
+// SetQueueLimits sets the maximum number of jobs waiting for a render slot, and how long they wait
+func (e *Engine) SetQueueLimits(maxDepth int, maxWait time.Duration) {
+	e.Queue.SetLimits(maxDepth, maxWait)
+}
+
+func (e *Engine) RenderJob(job *Job) (result *JobResult) {
 	jobId := job.Id.String()
-	e.logger.Debug("starting job...", log.KV{"id": jobId, "job": job})
+	e.logger.Info("starting job...", job.LogFields())
+
+	var diag *Diagnostics
+	if job.Diagnostics {
+		// attach diagnostics to every result, once the page and the http server are closed
+		diag = NewDiagnostics()
+		defer func() {
+			result.Diagnostics = diag
+		}()
+	}
+
+	// the job is canceled when either the caller or the application context is done
+	ctx, cancel := e.jobContext(job)
+	defer cancel()
+	var pageCtx context.Context // bounded by the job timeout
+	defer func() {
+		var tplErr *TemplateError
+		switch {
+		case result.Success:
+		case job.Context != nil && job.Context.Err() != nil:
+			e.logger.Warn("job canceled", log.KV{"id": jobId})
+			result.Error = fmt.Errorf("%w: %w", ErrJobCanceled, job.Context.Err())
+		case errors.Is(context.Cause(e.jobsCtx), ErrShuttingDown):
+			e.logger.Warn("job canceled by shutdown", log.KV{"id": jobId})
+			result.Error = ErrShuttingDown
+		case pageCtx != nil && errors.Is(pageCtx.Err(), context.DeadlineExceeded) && !errors.As(result.Error, &tplErr):
+			e.logger.Warn("job timed out", log.KV{"id": jobId, "timeout": job.JobTimeoutS})
+			result.Error = fmt.Errorf("%w: %w", ErrJobTimeout, result.Error)
+		}
+	}()
 
 	// Validate timeouts to prevent immediately-canceled contexts
 	jobTimeout := job.JobTimeoutS
@@ -85,6 +141,53 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 		jsTimeout = JobDefaultJsTimeout
 	}
 
+	// the ephemeral server answers missing files with 404, so a missing index would render the error page
+	if job.Zpt != nil && !job.HasIndex() {
+		e.logger.Warn("job rejected", log.KV{"id": jobId, "error": zpt.ErrIndexNotFound.Error(), "index": job.IndexFile})
+		return &JobResult{
+			ElapsedTime: 0,
+			Success:     false,
+			Output:      nil,
+			Error:       zpt.ErrIndexNotFound,
+		}
+	}
+
+	// wait for a render slot; slots are released once the server and browser are returned to their pools
+	ticket := job.Ticket
+	if ticket == nil {
+		var err error
+		if ticket, err = e.Queue.Reserve(job.Client); err != nil {
+			e.logger.Warn("job rejected", log.KV{"id": jobId, "error": err.Error()})
+			return &JobResult{
+				ElapsedTime: 0,
+				Success:     false,
+				Output:      nil,
+				Error:       err,
+			}
+		}
+	}
+	defer ticket.Release()
+	if !e.begin() {
+		e.logger.Warn("job rejected", log.KV{"id": jobId, "error": ErrShuttingDown.Error()})
+		return &JobResult{
+			ElapsedTime: 0,
+			Success:     false,
+			Output:      nil,
+			Error:       ErrShuttingDown,
+		}
+	}
+	defer e.end()
+	ticket.Assign(job.Priority)
+	if err := ticket.Wait(ctx); err != nil {
+		e.logger.Warn("job rejected", log.KV{"id": jobId, "error": err.Error()})
+		return &JobResult{
+			ElapsedTime: 0,
+			Success:     false,
+			Output:      nil,
+			Error:       err,
+		}
+	}
+
 	server := e.ServerPool.BuildServer(job.Zpt)
 	if server == nil {
 		err := errors.New("failed to build server")
@@ -97,6 +200,12 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 		}
 	}
 	defer e.ServerPool.RemoveServer(server)
+	if diag != nil {
+		server.OnNotFound(diag.AddNotFound)
+	}
+	if len(job.Data) > 0 {
+		server.SetData(job.Data)
+	}
 	e.logger.Info("started ephemeral http server", log.KV{"id": jobId, "address": server.Server.Addr})
 
 	browser, err := e.GetBrowser()
@@ -104,12 +213,13 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 		// Get() consumed a pool slot even though creation failed; return an
 		// empty slot so the pool capacity is not permanently reduced.
 		e.BrowserPool.Put(nil)
+		e.health.record(err, "")
 		e.logger.Error(err, "could not fetch browser instance", log.KV{"id": jobId})
 		return &JobResult{
 			ElapsedTime: 0,
 			Success:     false,
 			Output:      nil,
-			Error:       err,
+			Error:       fmt.Errorf("%w: %w", ErrBrowserUnavailable, err),
 		}
 	}
 	// Track whether browser is healthy; only return to pool if so
@@ -120,18 +230,12 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 			return
 		}
 		e.logger.Warn("discarding broken browser instance", log.KV{"id": jobId})
-		e.metrics.Browsers.Dec()
-		// In shared-launcher mode every pooled browser is a connection to the
-		// same Chrome process; closing it would terminate rendering for all
-		// concurrent jobs. Drop the connection instead. In dedicated mode each
-		// browser is its own process, so close it to release resources.
-		if e.launcherURL == "" {
-			_ = browser.Close()
-		}
-		// Return an empty slot so a fresh browser is created on the next Get.
-		e.BrowserPool.Put(nil)
+		e.discardBrowser(browser)
 	}()
 	e.logger.Info("browser acquired", log.KV{"id": jobId})
+	if job.OnStart != nil {
+		job.OnStart()
+	}
 
 	start := time.Now()
 	err = browser.IgnoreCertErrors(job.IgnoreSSLErrors)
@@ -141,12 +245,13 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 			ElapsedTime: 0,
 			Success:     false,
 			Output:      nil,
-			Error:       err,
+			Error:       fmt.Errorf("%w: %w", ErrBrowserUnavailable, err),
 		}
 	}
 
 	url := "http://" + server.Server.Addr + "/" + job.IndexFile
-	pageCtx, pageCancel := context.WithTimeout(e.ctx, time.Duration(jobTimeout)*time.Second)
+	var pageCancel context.CancelFunc
+	pageCtx, pageCancel = context.WithTimeout(ctx, time.Duration(jobTimeout)*time.Second)
 	page, err := browser.Context(pageCtx).Page(proto.TargetCreateTarget{})
 	if err != nil {
 		pageCancel()
@@ -155,11 +260,12 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 			ElapsedTime: time.Since(start).Seconds(),
 			Success:     false,
 			Output:      nil,
-			Error:       err,
+			Error:       fmt.Errorf("%w: %w", ErrBrowserUnavailable, err),
 		}
 	}
 	// Page created successfully — browser connection is alive
 	browserOK = true
+	e.health.record(nil, "")
 
 	// WaitGroup to synchronize event goroutines with page closure
 	var evtWg sync.WaitGroup
@@ -169,61 +275,116 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 		_ = page.Context(e.ctx).Close() // close tab using a live context
 	}()
 
-	if job.UseJSEvent {
-		// render using jsEvent
-		e.logger.Debug("using JS trigger - console message", log.KV{"id": jobId})
+	// job data, viewport and background for image output
+	if err = job.preparePage(page); err != nil {
+		e.logger.Error(err, "failed to prepare page", log.KV{"id": jobId})
+		return &JobResult{
+			ElapsedTime: time.Since(start).Seconds(),
+			Success:     false,
+			Output:      nil,
+			Error:       err,
+		}
+	}
 
-		// wait for complete event before proceeding
-		timeout := make(chan bool, 1)
-		done := make(chan struct{})
-		closed := atomic.Int32{}
+	waiter := newReadyWaiter(job.ReadyConditions())
 
-		evtWg.Add(1)
-		go func() {
-			defer evtWg.Done()
-			page.EachEvent(func(evt *proto.RuntimeConsoleAPICalled) {
-				if e.consoleLogging {
+	// subscribe to page events before navigating, so no early event is lost
+	handlers := []interface{}{
+		func(evt *proto.RuntimeConsoleAPICalled) {
+			if e.consoleLogging || diag != nil {
+				parts := consoleArgs(page, evt.Args)
+				if e.consoleLogging && len(parts) > 0 {
 					// log JS console output
-					e.logConsoleArgs(page, evt.Args, jobId)
+					e.logger.Info(fmt.Sprintf("%v", parts), log.KV{"id": jobId, "src": "js console"})
 				}
-
-				if len(evt.Args) > 0 {
-					if val, err := page.ObjectToJSON(evt.Args[0]); err == nil && val.String() == "zpt-view-ready" {
-						e.logger.Debug("console message received", log.KV{"id": jobId})
-						if closed.CompareAndSwap(0, 1) {
-							close(done)
-						}
-					}
+				if diag != nil {
+					diag.AddConsole(string(evt.Type), parts)
+				}
+			}
+			if len(evt.Args) > 0 {
+				val, err := page.ObjectToJSON(evt.Args[0])
+				if err != nil {
+					return
 				}
-			},
-				func(evt *proto.LogEntryAdded) {
-					if e.consoleLogging && evt.Entry != nil {
-						msg, _ := json.Marshal(evt.Entry)
-						e.logger.Info("console message received", log.KV{"id": jobId, "src": "logger", "message": string(msg)})
+				if val.String() == ErrorConsoleMessage {
+					// template signalled an error, abort the job immediately
+					if tplErr := newTemplateError(page, evt.Args); waiter.fail(tplErr) {
+						e.logger.Warn("template signalled an error", log.KV{"id": jobId, "code": tplErr.Code, "message": tplErr.Message})
+						pageCancel()
 					}
-				})()
-		}()
-
-		// JS timeout procedure
-		cancel := time.AfterFunc(time.Duration(jsTimeout)*time.Second, func() {
-			close(timeout)
+				} else if waiter.consoleMessage(val.String()) {
+					e.logger.Debug("console message received", log.KV{"id": jobId, "message": val.String()})
+				}
+			}
+		},
+		func(evt *proto.LogEntryAdded) {
+			if e.consoleLogging && evt.Entry != nil {
+				msg, _ := json.Marshal(evt.Entry)
+				e.logger.Info(string(msg), log.KV{"id": jobId, "src": "logger"})
+			}
+			if diag != nil {
+				diag.AddLog(evt.Entry)
+			}
+		},
+	}
+	if diag != nil {
+		handlers = append(handlers, func(evt *proto.RuntimeExceptionThrown) {
+			diag.AddException(evt.ExceptionDetails)
 		})
-		defer cancel.Stop()
+	}
+	if waiter.tracksNetwork() {
+		handlers = append(handlers, waiter.networkHandlers()...)
+	}
+	eachEvent := page.EachEvent(handlers...)
+	evtWg.Add(1)
+	go func() {
+		defer evtWg.Done()
+		eachEvent()
+	}()
 
-		wait := page.WaitEvent(&proto.PageLoadEventFired{})
-		err = page.Navigate(url)
-		if err != nil {
-			e.logger.Error(err, "failed to navigate", log.KV{"id": jobId})
+	err = page.Navigate(url)
+	if err = waiter.failureOr(err); err != nil {
+		e.logger.Error(err, "failed to navigate", log.KV{"id": jobId})
+		return &JobResult{
+			ElapsedTime: time.Since(start).Seconds(),
+			Success:     false,
+			Output:      nil,
+			Error:       job.navigationError(err),
+		}
+	}
+	err = page.WaitLoad()
+	if err = waiter.failureOr(err); err != nil {
+		e.logger.Error(err, "failed to wait for page load", log.KV{"id": jobId})
+		return &JobResult{
+			ElapsedTime: time.Since(start).Seconds(),
+			Success:     false,
+			Output:      nil,
+			Error:       job.navigationError(err),
+		}
+	}
+
+	if len(waiter.conditions) > 0 {
+		// wait for all readiness conditions, bounded by the JS timeout
+		e.logger.Debug("waiting for readiness conditions", log.KV{"id": jobId, "conditions": len(waiter.conditions)})
+		waitCtx, waitCancel := context.WithTimeout(pageCtx, time.Duration(jsTimeout)*time.Second)
+		err = waiter.wait(waitCtx, page)
+		waitCancel()
+		if ctx.Err() != nil {
 			return &JobResult{
 				ElapsedTime: time.Since(start).Seconds(),
 				Success:     false,
 				Output:      nil,
-				Error:       err,
+				Error:       ctx.Err(),
 			}
 		}
-		err = page.WaitLoad()
-		if err != nil {
-			e.logger.Error(err, "failed to wait for page load", log.KV{"id": jobId})
+		if errors.Is(err, ErrReadyTimeout) {
+			e.metrics.ReadyTimeouts.Inc()
+			e.logger.Warn("waiting for readiness conditions timed out", log.KV{"id": jobId, "strict": job.StrictReady})
+		} else if err != nil {
+			e.logger.Warn("readiness condition failed", log.KV{"id": jobId, "strict": job.StrictReady, "error": err.Error()})
+		}
+		var tplErr *TemplateError
+		if errors.As(err, &tplErr) || (err != nil && job.StrictReady) {
 			return &JobResult{
 				ElapsedTime: time.Since(start).Seconds(),
 				Success:     false,
@@ -231,64 +392,35 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 				Error:       err,
 			}
 		}
-		wait()
-		select {
-		case <-done:
-			break
-		case <-timeout:
-			e.logger.Warn("waiting for console message timed out", log.KV{"id": jobId})
-		}
 	} else {
-		e.logger.Debug("using regular rendering", log.KV{"id": jobId})
-
-		if e.consoleLogging {
-			// log console & browser logger
-			evtWg.Add(1)
-			go func() {
-				defer evtWg.Done()
-				page.EachEvent(func(evt *proto.RuntimeConsoleAPICalled) {
-					e.logConsoleArgs(page, evt.Args, jobId)
-				},
-					func(evt *proto.LogEntryAdded) {
-						if evt.Entry != nil {
-							msg, _ := json.Marshal(evt.Entry)
-							e.logger.Info(string(msg), log.KV{"id": jobId, "src": "logger"})
-						}
-					})()
-			}()
-		}
-
-		// no JS event, proceed as expected
-		err = page.Navigate(url)
-		if err != nil {
-			e.logger.Error(err, "failed to navigate", log.KV{"id": jobId})
+		// no readiness conditions, use settling time
+		select {
+		case <-time.After(time.Duration(job.JobSettlingTimeMs) * time.Millisecond):
+		case <-waiter.failed:
+			err = waiter.failureOr(nil)
 			return &JobResult{
 				ElapsedTime: time.Since(start).Seconds(),
 				Success:     false,
 				Output:      nil,
 				Error:       err,
 			}
-		}
-		err = page.WaitLoad()
-		if err != nil {
-			e.logger.Error(err, "failed to wait for page load", log.KV{"id": jobId})
+		case <-ctx.Done():
 			return &JobResult{
 				ElapsedTime: time.Since(start).Seconds(),
 				Success:     false,
 				Output:      nil,
-				Error:       err,
+				Error:       ctx.Err(),
 			}
 		}
-		// settling time
-		time.Sleep(time.Duration(job.JobSettlingTimeMs) * time.Millisecond)
 	}
-	pdf, err := page.PDF(job.ToPDFOptions())
-	var buf []byte = nil
-	if err == nil {
-		buf, err = io.ReadAll(pdf)
+	buf, err := job.renderOutput(page)
+	if err != nil {
+		e.logger.Error(err, "failed to generate output", log.KV{"id": jobId})
+		err = job.outputError(err)
 	}
+	err = waiter.failureOr(err)
 	elapsed := time.Since(start)
-	result := &JobResult{
+	result = &JobResult{
 		ElapsedTime: elapsed.Seconds(),
 		Success:     err == nil,
 		Output:      buf,
@@ -303,16 +435,97 @@ func (e *Engine) RenderJob(job *Job) *JobResult {
 	return result
 }
 
-func (e *Engine) logConsoleArgs(page *rod.Page, args []*proto.RuntimeRemoteObject, jobId string) {
+// jobContext returns the context of a job, canceled when the job context or the engine job context is done
+func (e *Engine) jobContext(job *Job) (context.Context, context.CancelFunc) {
+	if job.Context == nil {
+		return context.WithCancel(e.jobsCtx)
+	}
+	ctx, cancel := context.WithCancel(job.Context)
+	stop := context.AfterFunc(e.jobsCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// begin registers a running job; it returns false if the engine is draining
+func (e *Engine) begin() bool {
+	e.jobsMx.Lock()
+	defer e.jobsMx.Unlock()
+	if e.draining {
+		return false
+	}
+	e.running++
+	return true
+}
+
+// end unregisters a running job
+func (e *Engine) end() {
+	e.jobsMx.Lock()
+	defer e.jobsMx.Unlock()
+	e.running--
+	if e.draining && e.running == 0 {
+		close(e.drained)
+	}
+}
+
+// Draining returns true once Drain is called; new jobs are rejected with ErrShuttingDown
+func (e *Engine) Draining() bool {
+	e.jobsMx.Lock()
+	defer e.jobsMx.Unlock()
+	return e.draining
+}
+
+// Drain stops accepting jobs, and waits up to grace for the running jobs to finish; remaining jobs are then
+// canceled with ErrShuttingDown
+func (e *Engine) Drain(grace time.Duration) {
+	e.jobsMx.Lock()
+	if !e.draining {
+		e.draining = true
+		if e.running == 0 {
+			close(e.drained)
+		}
+	}
+	running := e.running
+	e.jobsMx.Unlock()
+	if running == 0 {
+		return
+	}
+
+	e.logger.Info("draining render jobs...", log.KV{"running": running, "grace": grace.String()})
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-e.drained:
+		e.logger.Info("render jobs drained")
+		return
+	case <-timer.C:
+	}
+
+	e.logger.Warn("drain grace period expired, canceling render jobs", log.KV{"running": e.runningJobs()})
+	e.cancelJobs(ErrShuttingDown)
+	select {
+	case <-e.drained:
+	case <-time.After(DrainCancelTimeout):
+		e.logger.Warn("canceled render jobs did not finish", log.KV{"running": e.runningJobs()})
+	}
+}
+
+func (e *Engine) runningJobs() int {
+	e.jobsMx.Lock()
+	defer e.jobsMx.Unlock()
+	return e.running
+}
+
+// consoleArgs converts JS console arguments to strings
+func consoleArgs(page *rod.Page, args []*proto.RuntimeRemoteObject) []string {
 	var parts []string
 	for _, obj := range args {
 		if val, err := page.ObjectToJSON(obj); err == nil {
 			parts = append(parts, val.String())
 		}
 	}
-	if len(parts) > 0 {
-		e.logger.Info(fmt.Sprintf("%v", parts), log.KV{"id": jobId, "src": "js console"})
-	}
+	return parts
 }
 
 func (e *Engine) GetBrowser() (*rod.Browser, error) {
@@ -328,8 +541,7 @@ func (e *Engine) connectBrowser() (*rod.Browser, error) {
 		if err := b.Connect(); err != nil {
 			return nil, err
 		}
-		e.metrics.Browsers.Inc()
-		return b, nil
+		return e.connected(b), nil
 	}
 
 	e.launcherMx.Lock()
@@ -338,8 +550,7 @@ func (e *Engine) connectBrowser() (*rod.Browser, error) {
 
 	b := rod.New().ControlURL(url).Context(e.ctx)
 	if err := b.Connect(); err == nil {
-		e.metrics.Browsers.Inc()
-		return b, nil
+		return e.connected(b), nil
 	}
 
 	// The shared Chrome may have died; relaunch it once and retry.
@@ -351,8 +562,7 @@ func (e *Engine) connectBrowser() (*rod.Browser, error) {
 	if err := b.Connect(); err != nil {
 		return nil, err
 	}
-	e.metrics.Browsers.Inc()
-	return b, nil
+	return e.connected(b), nil
 }
 
 // relaunchSharedBrowser relaunches the shared no-sandbox Chrome, unless another
@@ -392,8 +602,10 @@ func needsNoSandbox() bool {
 
 func (e *Engine) Shutdown() {
 	e.logger.Info("Shutting down render.Engine...")
+	e.cancelJobs(ErrShuttingDown)
 	e.BrowserPool.Cleanup(func(p *rod.Browser) {
 		e.metrics.Browsers.Dec()
+		e.health.track(p, false)
 		// In shared-launcher mode, the first Close() kills Chrome and
 		// subsequent calls fail; use non-panicking Close to handle this.
 		_ = p.Close()
@@ -0,0 +1,128 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"zipreport-server/pkg/zpt"
+)
+
+// Error codes, reported to clients
+const CodeInternal = "internal_error"
+const CodeInvalidArchive = "invalid_archive"
+const CodeIndexNotFound = "index_not_found"
+const CodeNavigationFailed = "navigation_failed"
+const CodeReadyTimeout = "ready_timeout"
+const CodeJobTimeout = "job_timeout"
+const CodeBrowserUnavailable = "browser_unavailable"
+const CodePdfFailed = "pdf_failed"
+const CodeOutputFailed = "output_failed" // image and snapshot output formats
+const CodeTooManyPages = "too_many_pages"
+const CodeQueueFull = "queue_full"
+const CodeQueueTimeout = "queue_timeout"
+const CodeCanceled = "canceled"
+const CodeShuttingDown = "shutting_down"
+
+// MessageInternal is the message of errors without a code; error details are only logged
+const MessageInternal = "unexpected server error"
+
+var ErrNavigationFailed = errors.New("failed to load the report page")
+var ErrJobTimeout = errors.New("render job timed out")
+var ErrBrowserUnavailable = errors.New("browser unavailable")
+var ErrPdfFailed = errors.New("failed to generate pdf")
+var ErrOutputFailed = errors.New("failed to generate output")
+var ErrTooManyPages = fmt.Errorf("paginated output exceeds %d pages", JobMaxPages)
+
+// errorCodes maps errors to their code and retryability; the first match is used, so errors wrapping other
+// known errors (e.g. a job timeout while navigating) must be listed first
+var errorCodes = []struct {
+	err       error
+	code      string
+	retryable bool
+}{
+	{ErrShuttingDown, CodeShuttingDown, true},
+	{ErrJobCanceled, CodeCanceled, false},
+	{ErrQueueFull, CodeQueueFull, true},
+	{ErrQueueTimeout, CodeQueueTimeout, true},
+	{ErrJobTimeout, CodeJobTimeout, true},
+	{ErrReadyTimeout, CodeReadyTimeout, false},
+	{zpt.ErrInvalidArchive, CodeInvalidArchive, false},
+	{zpt.ErrIndexNotFound, CodeIndexNotFound, false},
+	{ErrBrowserUnavailable, CodeBrowserUnavailable, true},
+	{ErrNavigationFailed, CodeNavigationFailed, false},
+	{ErrTooManyPages, CodeTooManyPages, false},
+	{ErrPdfFailed, CodePdfFailed, true},
+	{ErrOutputFailed, CodeOutputFailed, true},
+}
+
+// navigationError classifies a failure to load the report page; template errors are returned as is
+func (r *Job) navigationError(err error) error {
+	var tplErr *TemplateError
+	switch {
+	case errors.As(err, &tplErr):
+		return err
+	case r.Zpt != nil && !r.HasIndex():
+		return fmt.Errorf("%w: %w", zpt.ErrIndexNotFound, err)
+	}
+	return fmt.Errorf("%w: %w", ErrNavigationFailed, err)
+}
+
+// outputError classifies a failure to generate the job output; page limit errors are returned as is
+func (r *Job) outputError(err error) error {
+	if errors.Is(err, ErrTooManyPages) {
+		return err
+	}
+	if r.OutputFormat == OutputPDF {
+		return fmt.Errorf("%w: %w", ErrPdfFailed, err)
+	}
+	return fmt.Errorf("%w: %w", ErrOutputFailed, err)
+}
+
+// ErrorCode returns the code of a job error; template errors use the code signalled by the template, and
+// unknown errors are CodeInternal
+func ErrorCode(err error) string {
+	var tplErr *TemplateError
+	if errors.As(err, &tplErr) {
+		return tplErr.Code
+	}
+	for _, item := range errorCodes {
+		if errors.Is(err, item.err) {
+			return item.code
+		}
+	}
+	return CodeInternal
+}
+
+// ErrorMessage returns the message of a job error, safe to return to clients
+func ErrorMessage(err error) string {
+	var tplErr *TemplateError
+	if errors.As(err, &tplErr) {
+		return tplErr.Message
+	}
+	for _, item := range errorCodes {
+		if errors.Is(err, item.err) {
+			return item.err.Error()
+		}
+	}
+	return MessageInternal
+}
+
+// Retryable returns true if a job that failed with code may succeed if submitted again
+func Retryable(code string) bool {
+	for _, item := range errorCodes {
+		if item.code == code {
+			return item.retryable
+		}
+	}
+	return false
+}
+
+// ErrorFromCode returns the error for a code, e.g. to rebuild a stored job result; unknown codes return an
+// error with the given message
+func ErrorFromCode(code string, message string) error {
+	for _, item := range errorCodes {
+		if item.code == code {
+			return item.err
+		}
+	}
+	return errors.New(message)
+}
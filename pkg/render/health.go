@@ -0,0 +1,284 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/oddbit-project/blueprint/log"
+)
+
+// BrowserCheckTTL is the time a browser or launcher check result is reused, before probing again
+const BrowserCheckTTL = 30 * time.Second
+
+// HealthCheckTimeout bounds each readiness probe
+const HealthCheckTimeout = 5 * time.Second
+
+var ErrNoBrowserResponse = errors.New("no connected browser responded")
+
+// CheckResult is the result of a readiness check
+type CheckResult struct {
+	Ok        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Version   string    `json:"version,omitempty"` // browser version, if known
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// SlotStatus reports the render slots usage
+type SlotStatus struct {
+	Ok      bool `json:"ok"` // at least one render slot is free
+	Size    int  `json:"size"`
+	Free    int  `json:"free"`
+	Waiting int  `json:"waiting"`
+}
+
+// Readiness is the readiness state of the engine
+type Readiness struct {
+	Ready    bool         `json:"ready"`
+	Draining bool         `json:"draining"`
+	Browser  CheckResult  `json:"browser"`
+	Launcher *CheckResult `json:"launcher,omitempty"` // shared launcher mode only
+	Slots    SlotStatus   `json:"slots"`
+}
+
+// browserHealth caches the last browser and launcher checks, and tracks the connected browsers
+type browserHealth struct {
+	mx       sync.Mutex
+	probeMx  sync.Mutex // serializes probes, so concurrent readiness requests share one check
+	result   CheckResult
+	launcher CheckResult
+	version  string // last known browser version
+	browsers map[*rod.Browser]struct{}
+}
+
+// record stores the result of a browser check; jobs record the outcome of acquiring a browser, so idle
+// probes are only needed when no jobs are running
+func (h *browserHealth) record(err error, version string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if len(version) > 0 {
+		h.version = version
+	}
+	h.result = CheckResult{Ok: err == nil, Version: h.version, CheckedAt: time.Now().UTC()}
+	if err != nil {
+		h.result.Error = err.Error()
+	}
+}
+
+// recent returns the cached result, if newer than BrowserCheckTTL
+func (h *browserHealth) recent() (CheckResult, bool) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.result, !h.result.CheckedAt.IsZero() && time.Since(h.result.CheckedAt) < BrowserCheckTTL
+}
+
+// recentLauncher returns the cached launcher check, if newer than BrowserCheckTTL
+func (h *browserHealth) recentLauncher() (CheckResult, bool) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.launcher, !h.launcher.CheckedAt.IsZero() && time.Since(h.launcher.CheckedAt) < BrowserCheckTTL
+}
+
+// track adds or removes a connected browser
+func (h *browserHealth) track(b *rod.Browser, connected bool) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if h.browsers == nil {
+		h.browsers = make(map[*rod.Browser]struct{})
+	}
+	if connected {
+		h.browsers[b] = struct{}{}
+	} else {
+		delete(h.browsers, b)
+	}
+}
+
+// connectedBrowsers returns the connected browsers, idle or in use by jobs
+func (h *browserHealth) connectedBrowsers() []*rod.Browser {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	result := make([]*rod.Browser, 0, len(h.browsers))
+	for b := range h.browsers {
+		result = append(result, b)
+	}
+	return result
+}
+
+// Ready checks that the engine can render jobs: it is not draining, a connected browser responds, the shared
+// launcher is reachable, if used, and a render slot is free, unless disabled with SetReadyRequireFreeSlot.
+// Browser and launcher checks are cached for BrowserCheckTTL, so probes are answered without waiting for the browser
+func (e *Engine) Ready() *Readiness {
+	r := &Readiness{
+		Draining: e.Draining(),
+		Slots:    e.slotStatus(),
+	}
+	if r.Draining {
+		// don't probe browsers while shutting down
+		r.Browser, _ = e.health.recent()
+		return r
+	}
+	e.health.probeMx.Lock()
+	defer e.health.probeMx.Unlock()
+	r.Browser = e.CheckBrowser()
+	e.launcherMx.Lock()
+	launcherURL := e.launcherURL
+	e.launcherMx.Unlock()
+	if len(launcherURL) > 0 {
+		launcher, ok := e.health.recentLauncher()
+		if !ok {
+			launcher = checkLauncher(launcherURL)
+			e.health.mx.Lock()
+			e.health.launcher = launcher
+			e.health.mx.Unlock()
+		}
+		r.Launcher = &launcher
+	}
+	slotsOk := r.Slots.Ok || e.readyIgnoreSlots.Load()
+	r.Ready = !r.Draining && r.Browser.Ok && slotsOk && (r.Launcher == nil || r.Launcher.Ok)
+	return r
+}
+
+// SetReadyRequireFreeSlot sets whether readiness fails while all render slots are busy (default true)
+func (e *Engine) SetReadyRequireFreeSlot(required bool) {
+	e.readyIgnoreSlots.Store(!required)
+}
+
+// CheckBrowser returns a recent browser check result, or probes the connected browsers; browsers are not taken
+// from the pool, so probes don't compete with jobs for render slots. If no browser is connected, a browser is
+// connected and probed, so a missing or broken Chrome is reported before the first job
+func (e *Engine) CheckBrowser() CheckResult {
+	if result, ok := e.health.recent(); ok {
+		return result
+	}
+	browsers := e.health.connectedBrowsers()
+	if len(browsers) == 0 {
+		return e.probeNewBrowser()
+	}
+
+	// any responding browser passes; broken browsers are discarded by the jobs using them
+	err := ErrNoBrowserResponse
+	for _, b := range browsers {
+		ctx, cancel := context.WithTimeout(e.ctx, HealthCheckTimeout)
+		version, verr := proto.BrowserGetVersion{}.Call(b.Context(ctx))
+		cancel()
+		if verr == nil {
+			e.health.record(nil, version.Product)
+			result, _ := e.health.recent()
+			return result
+		}
+		err = fmt.Errorf("%w: %w", ErrNoBrowserResponse, verr)
+	}
+	e.logger.Warn("browser check failed", log.KV{"error": err.Error()})
+	e.health.record(err, "")
+	result, _ := e.health.recent()
+	return result
+}
+
+// probeNewBrowser connects a browser and checks it responds; the browser is kept in an empty pool slot, if there is
+// one, and closed otherwise
+func (e *Engine) probeNewBrowser() CheckResult {
+	b, err := e.connectBrowser()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(e.ctx, HealthCheckTimeout)
+		var version *proto.BrowserGetVersionResult
+		version, err = proto.BrowserGetVersion{}.Call(b.Context(ctx))
+		cancel()
+		if err == nil {
+			e.health.record(nil, version.Product)
+			e.keepBrowser(b)
+		} else {
+			e.dropBrowser(b)
+		}
+	}
+	if err != nil {
+		e.logger.Warn("browser check failed", log.KV{"error": err.Error()})
+		e.health.record(err, "")
+	}
+	result, _ := e.health.recent()
+	return result
+}
+
+// keepBrowser puts a probed browser in an empty pool slot, without waiting; if no slot is empty, it is closed
+func (e *Engine) keepBrowser(b *rod.Browser) {
+	select {
+	case slot := <-e.BrowserPool:
+		if slot == nil {
+			e.BrowserPool.Put(b)
+			return
+		}
+		e.BrowserPool.Put(slot)
+	default:
+	}
+	e.dropBrowser(b)
+}
+
+// connected tracks a newly connected browser, and records its version
+func (e *Engine) connected(b *rod.Browser) *rod.Browser {
+	e.metrics.Browsers.Inc()
+	e.health.track(b, true)
+	if version, err := b.Version(); err == nil {
+		e.health.record(nil, version.Product)
+	}
+	return b
+}
+
+// BrowserVersion returns the version of the running browser, if known
+func (e *Engine) BrowserVersion() string {
+	e.health.mx.Lock()
+	defer e.health.mx.Unlock()
+	return e.health.version
+}
+
+// discardBrowser drops a broken browser and returns an empty slot to the pool
+func (e *Engine) discardBrowser(b *rod.Browser) {
+	e.dropBrowser(b)
+	// Return an empty slot so a fresh browser is created on the next Get.
+	e.BrowserPool.Put(nil)
+}
+
+// dropBrowser stops tracking a browser, and closes it unless it is a connection to the shared launcher
+func (e *Engine) dropBrowser(b *rod.Browser) {
+	e.metrics.Browsers.Dec()
+	e.health.track(b, false)
+	// In shared-launcher mode every pooled browser is a connection to the
+	// same Chrome process; closing it would terminate rendering for all
+	// concurrent jobs. Drop the connection instead. In dedicated mode each
+	// browser is its own process, so close it to release resources.
+	if e.launcherURL == "" {
+		_ = b.Close()
+	}
+}
+
+// slotStatus reports the render slots usage
+func (e *Engine) slotStatus() SlotStatus {
+	size, free, waiting := e.Queue.Stats()
+	return SlotStatus{
+		Ok:      free > 0,
+		Size:    size,
+		Free:    free,
+		Waiting: waiting,
+	}
+}
+
+// checkLauncher checks that the shared launcher accepts connections
+func checkLauncher(launcherURL string) CheckResult {
+	result := CheckResult{CheckedAt: time.Now().UTC()}
+	u, err := url.Parse(launcherURL)
+	if err == nil {
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", u.Host, HealthCheckTimeout); err == nil {
+			_ = conn.Close()
+		}
+	}
+	result.Ok = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
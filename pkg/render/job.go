@@ -1,10 +1,15 @@
 package render
 
 import (
+	"context"
+	"errors"
+	"html"
+	"strings"
 	"zipreport-server/pkg/zpt"
 
 	"github.com/go-rod/rod/lib/proto"
 	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
 )
 
 // Page Sizes
@@ -14,6 +19,7 @@ const PageA5 = "A5"
 const PageLetter = "Letter"
 const PageLegal = "Legal"
 const PageTabloid = "Tabloid"
+const PageCustom = "custom" // uses PageWidth and PageHeight
 
 // Margins
 const MarginStandard = "standard"
@@ -25,6 +31,8 @@ const MarginCustom = "custom"
 const JobDefaultTimeout = 120
 const JobDefaultJsTimeout = 30
 const JobDefaultSettlingTime = 200
+const JobDefaultHeaderHeight = 0.4 // inches, used when a header template has no explicit height
+const JobDefaultFooterHeight = 0.4 // inches, used when a footer template has no explicit height
 
 // Upper bounds for client-supplied timing values, to prevent a client from
 // holding a pool slot indefinitely.
@@ -32,22 +40,59 @@ const JobMaxTimeout = 600        // seconds
 const JobMaxJsTimeout = 300      // seconds
 const JobMaxSettlingTime = 30000 // milliseconds
 
+// MaxTemplateSize caps the size of header/footer templates
+const MaxTemplateSize = 64 << 10 // 64 KiB
+
+var ErrInvalidTemplate = errors.New("invalid header or footer template")
+
+// Server-side header/footer placeholders, replaced before the template is handed
+// to Chrome; Chrome's own pageNumber, totalPages, date and title classes are kept as-is
+const PlaceholderRequestId = "{{request_id}}"
+const PlaceholderPageSize = "{{page_size}}"
+const PlaceholderIndexFile = "{{script}}"
+
 type Job struct {
-	Id                uuid.UUID
-	Zpt               *zpt.ZptReader
-	IndexFile         string
-	PageSize          string
-	MarginStyle       string
-	MarginLeft        float64 // custom margins
-	MarginRight       float64
-	MarginTop         float64
-	MarginBottom      float64
-	Landscape         bool
-	JobSettlingTimeMs int
-	JobTimeoutS       int
-	JsTimeoutS        int
-	UseJSEvent        bool
-	IgnoreSSLErrors   bool
+	Id                    uuid.UUID
+	Zpt                   *zpt.ZptReader `json:"-"`
+	Preset                string         // name of the applied preset, if any
+	IndexFile             string
+	PageSize              string
+	PageWidth             float64 // custom page size, in inches
+	PageHeight            float64
+	MarginStyle           string
+	MarginLeft            float64 // custom margins
+	MarginRight           float64
+	MarginTop             float64
+	MarginBottom          float64
+	Landscape             bool
+	JobSettlingTimeMs     int
+	JobTimeoutS           int
+	JsTimeoutS            int
+	UseJSEvent            bool
+	StrictReady           bool // fail the job if the readiness conditions are not met in time
+	IgnoreSSLErrors       bool
+	HeaderTemplate        string  // optional header html
+	FooterTemplate        string  // optional footer html
+	HeaderHeight          float64 // header height, in inches
+	FooterHeight          float64 // footer height, in inches
+	PrintBackground       bool    // print background graphics
+	PreferCSSPageSize     bool    // use @page size from CSS, if defined
+	OutputFormat          string  // pdf, png, jpeg or webp
+	ViewportWidth         int     // screenshot viewport, in CSS pixels
+	ViewportHeight        int
+	DeviceScaleFactor     float64
+	Quality               int             // jpeg/webp quality
+	FullPage              bool            // capture the whole page instead of the viewport
+	TransparentBackground bool            // png/webp only
+	Paginate              bool            // one image per printed page, as a zip archive
+	WaitFor               []WaitCondition // readiness conditions, bounded by JsTimeoutS
+	Diagnostics           bool            // collect console output, page errors and missing files
+	Data                  []byte          // optional JSON data, exposed as window.zptData
+	OnStart               func()          `json:"-"` // optional, called once a render slot is acquired
+	Ticket                *Ticket         `json:"-"` // optional place in the render queue, reserved by the caller
+	Context               context.Context `json:"-"` // optional caller context; the job is canceled when it is done
+	Priority              string          // queue priority class
+	Client                string          // client name, for fair scheduling between clients
 }
 
 type JobResult struct {
@@ -55,38 +100,65 @@ type JobResult struct {
 	Success     bool
 	Output      []byte
 	Error       error
+	Diagnostics *Diagnostics // nil unless requested
 }
 
-var ValidPageSizes = []string{PageA3, PageA4, PageA5, PageLetter, PageLegal, PageTabloid}
 var ValidMarginStyle = []string{MarginNone, MarginStandard, MarginMinimal, MarginCustom}
 
 func NewRenderJob(z *zpt.ZptReader, id uuid.UUID) *Job {
 	return &Job{
-		Id:                id,
-		Zpt:               z,
-		IndexFile:         zpt.DefaultScriptName,
-		PageSize:          PageA4,
-		MarginStyle:       MarginStandard,
-		MarginLeft:        0,
-		MarginRight:       0,
-		MarginTop:         0,
-		MarginBottom:      0,
-		Landscape:         false,
-		JobSettlingTimeMs: JobDefaultSettlingTime,
-		JobTimeoutS:       JobDefaultTimeout,
-		JsTimeoutS:        JobDefaultJsTimeout,
-		UseJSEvent:        false,
-		IgnoreSSLErrors:   false,
+		Id:                    id,
+		Zpt:                   z,
+		Preset:                "",
+		IndexFile:             zpt.DefaultScriptName,
+		PageSize:              PageA4,
+		PageWidth:             0,
+		PageHeight:            0,
+		MarginStyle:           MarginStandard,
+		MarginLeft:            0,
+		MarginRight:           0,
+		MarginTop:             0,
+		MarginBottom:          0,
+		Landscape:             false,
+		JobSettlingTimeMs:     JobDefaultSettlingTime,
+		JobTimeoutS:           JobDefaultTimeout,
+		JsTimeoutS:            JobDefaultJsTimeout,
+		UseJSEvent:            false,
+		StrictReady:           strictReadyDefault.Load(),
+		IgnoreSSLErrors:       false,
+		HeaderTemplate:        "",
+		FooterTemplate:        "",
+		HeaderHeight:          JobDefaultHeaderHeight,
+		FooterHeight:          JobDefaultFooterHeight,
+		PrintBackground:       false,
+		PreferCSSPageSize:     false,
+		OutputFormat:          OutputPDF,
+		ViewportWidth:         JobDefaultViewportWidth,
+		ViewportHeight:        JobDefaultViewportHeight,
+		DeviceScaleFactor:     JobDefaultDeviceScale,
+		Quality:               JobDefaultQuality,
+		FullPage:              false,
+		TransparentBackground: false,
+		Paginate:              false,
+		WaitFor:               nil,
+		Diagnostics:           false,
+		Data:                  nil,
+		Priority:              PriorityNormal,
+		Client:                DefaultClient,
 	}
 }
 
 func (r *Job) ToPDFOptions() *proto.PagePrintToPDF {
 	top, bottom, left, right := r.calcPaperMargin()
 	width, height := r.calcPaperSize()
+	header, footer := "", ""
+	if r.HasHeaderFooter() {
+		header, footer = r.expandTemplate(r.HeaderTemplate), r.expandTemplate(r.FooterTemplate)
+	}
 	return &proto.PagePrintToPDF{
 		Landscape:           r.Landscape,
-		DisplayHeaderFooter: false,
-		PrintBackground:     false,
+		DisplayHeaderFooter: r.HasHeaderFooter(),
+		PrintBackground:     r.PrintBackground,
 		Scale:               wrap(1.0),
 		PaperWidth:          width,
 		PaperHeight:         height,
@@ -95,44 +167,127 @@ func (r *Job) ToPDFOptions() *proto.PagePrintToPDF {
 		MarginLeft:          left,
 		MarginRight:         right,
 		PageRanges:          "",
-		HeaderTemplate:      "",
-		FooterTemplate:      "",
-		PreferCSSPageSize:   false,
+		HeaderTemplate:      header,
+		FooterTemplate:      footer,
+		PreferCSSPageSize:   r.PreferCSSPageSize,
 		TransferMode:        proto.PagePrintToPDFTransferModeReturnAsStream,
 	}
 }
 
+// Validate checks paper size and margins are within bounds, and leave room for content
+func (r *Job) Validate() error {
+	width, height := r.calcPaperSize()
+	if *width < MinPaperSize || *width > MaxPaperSize || *height < MinPaperSize || *height > MaxPaperSize {
+		return ErrInvalidPaperSize
+	}
+	top, bottom, left, right := r.calcPaperMargin()
+	for _, m := range []*float64{top, bottom, left, right} {
+		if *m < 0 {
+			return ErrInvalidMargins
+		}
+	}
+	// with CSS page size, the effective paper size is unknown at this point
+	if r.PreferCSSPageSize {
+		return nil
+	}
+	if r.Landscape {
+		width, height = height, width
+	}
+	if *left+*right >= *width || *top+*bottom >= *height {
+		return ErrInvalidMargins
+	}
+	return nil
+}
+
 // calcPaperSize()(width, height)
 func (r *Job) calcPaperSize() (*float64, *float64) {
-	switch r.PageSize {
-	case PageLetter:
-		return wrap(8.5), wrap(11)
-	case PageLegal:
-		return wrap(8.5), wrap(14)
-	case PageTabloid:
-		return wrap(11), wrap(17)
-	case PageA5:
-		return wrap(5.8), wrap(8.3)
-	case PageA3:
-		return wrap(11.7), wrap(16.5)
-	default: // PageA4
-		return wrap(8.3), wrap(11.7)
+	if r.PageSize == PageCustom {
+		return wrap(r.PageWidth), wrap(r.PageHeight)
+	}
+	size, ok := GetPaperSize(r.PageSize)
+	if !ok {
+		size, _ = GetPaperSize(PageA4)
+	}
+	return wrap(size.Width), wrap(size.Height)
+}
+
+// LogFields returns the effective job options, for logging purposes
+func (r *Job) LogFields() log.KV {
+	return log.KV{
+		"id":                r.Id.String(),
+		"preset":            r.Preset,
+		"indexFile":         r.IndexFile,
+		"pageSize":          r.PageSize,
+		"pageWidth":         r.PageWidth,
+		"pageHeight":        r.PageHeight,
+		"marginStyle":       r.MarginStyle,
+		"margins":           []float64{r.MarginTop, r.MarginBottom, r.MarginLeft, r.MarginRight},
+		"landscape":         r.Landscape,
+		"settlingTimeMs":    r.JobSettlingTimeMs,
+		"jobTimeoutS":       r.JobTimeoutS,
+		"jsTimeoutS":        r.JsTimeoutS,
+		"jsEvent":           r.UseJSEvent,
+		"jsEventStrict":     r.StrictReady,
+		"ignoreSslErrors":   r.IgnoreSSLErrors,
+		"header":            len(r.HeaderTemplate) > 0,
+		"footer":            len(r.FooterTemplate) > 0,
+		"printBackground":   r.PrintBackground,
+		"preferCssPageSize": r.PreferCSSPageSize,
+		"outputFormat":      r.OutputFormat,
+		"paginate":          r.Paginate,
+		"waitFor":           r.waitForNames(),
+		"diagnostics":       r.Diagnostics,
+		"dataSize":          len(r.Data),
+		"priority":          r.Priority,
+		"client":            r.Client,
 	}
 }
 
+// HasIndex returns true if the index file exists in the report; the query string and fragment are ignored
+func (r *Job) HasIndex() bool {
+	return r.Zpt != nil && r.Zpt.HasIndex(r.IndexFile)
+}
+
+// HasHeaderFooter returns true if either a header or a footer template is set
+func (r *Job) HasHeaderFooter() bool {
+	return len(r.HeaderTemplate) > 0 || len(r.FooterTemplate) > 0
+}
+
+// expandTemplate replaces server-side placeholders with job values
+// An empty template is replaced with an empty span, as Chrome renders its own default header/footer otherwise
+func (r *Job) expandTemplate(tpl string) string {
+	if len(tpl) == 0 {
+		return "<span></span>"
+	}
+	return strings.NewReplacer(
+		PlaceholderRequestId, r.Id.String(),
+		PlaceholderPageSize, html.EscapeString(r.PageSize),
+		PlaceholderIndexFile, html.EscapeString(r.IndexFile),
+	).Replace(tpl)
+}
+
 // calcPaperMargin()(top, bottom, left, right
+// header and footer heights are added to the top and bottom margins, so the content doesn't overlap them
 func (r *Job) calcPaperMargin() (*float64, *float64, *float64, *float64) {
+	var top, bottom, left, right float64
 	switch r.MarginStyle {
 	case MarginMinimal:
-		return wrap(0.2), wrap(0.2), wrap(0.2), wrap(0.2)
+		top, bottom, left, right = 0.2, 0.2, 0.2, 0.2
 	case MarginNone:
-		return wrap(0), wrap(0), wrap(0), wrap(0)
+		top, bottom, left, right = 0, 0, 0, 0
 	case MarginCustom:
-		return wrap(r.MarginTop), wrap(r.MarginBottom), wrap(r.MarginLeft), wrap(r.MarginRight)
+		top, bottom, left, right = r.MarginTop, r.MarginBottom, r.MarginLeft, r.MarginRight
 	// default: MarginStandard
 	default:
-		return wrap(0.4), wrap(0.4), wrap(0.4), wrap(0.4)
+		top, bottom, left, right = 0.4, 0.4, 0.4, 0.4
+	}
+	if len(r.HeaderTemplate) > 0 {
+		top += r.HeaderHeight
+	}
+	if len(r.FooterTemplate) > 0 {
+		bottom += r.FooterHeight
 	}
+	return wrap(top), wrap(bottom), wrap(left), wrap(right)
 }
 func wrap(v float64) *float64 {
 	return &v
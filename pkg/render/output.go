@@ -0,0 +1,292 @@
+package render
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Output formats
+const OutputPDF = "pdf"
+const OutputPNG = "png"
+const OutputJPEG = "jpeg"
+const OutputWebP = "webp"
+const OutputMHTML = "mhtml" // mhtml snapshot of the rendered page
+const OutputHTML = "html"   // self-contained html snapshot of the rendered page
+
+// Screenshot defaults
+const JobDefaultViewportWidth = 1280
+const JobDefaultViewportHeight = 1024
+const JobDefaultDeviceScale = 1.0
+const JobDefaultQuality = 90
+
+// Upper bounds for screenshot options; Chrome cannot capture images larger than 16384px
+const JobMaxViewportSize = 16384 // CSS pixels
+const JobMaxDeviceScale = 4.0
+const JobMinDeviceScale = 0.1
+const JobMaxQuality = 100
+
+// Paginated image output
+const JobMaxPages = 500       // maximum number of page images
+const CSSPixelsPerInch = 96.0 // CSS reference pixel density
+const ContentTypeZip = "application/zip"
+
+// pdfViewerParams hides the PDF viewer controls and fits the page to the viewport
+const pdfViewerParams = "toolbar=0&navpanes=0&scrollbar=0&view=Fit"
+
+// pdfRenderDelay is the time given to the PDF viewer to paint a page, once loaded
+const pdfRenderDelay = 250 * time.Millisecond
+
+var errNoPdfPages = errors.New("no pages found in the generated pdf")
+
+// pdfPageRegex matches page objects of the generated PDF; Chrome doesn't compress the object dictionaries
+var pdfPageRegex = regexp.MustCompile(`/Type\s*/Page\b`)
+
+var ValidOutputFormats = []string{OutputPDF, OutputPNG, OutputJPEG, OutputWebP, OutputMHTML, OutputHTML}
+
+var contentTypes = map[string]string{
+	OutputPDF:   "application/pdf",
+	OutputPNG:   "image/png",
+	OutputJPEG:  "image/jpeg",
+	OutputWebP:  "image/webp",
+	OutputMHTML: "multipart/related",
+	OutputHTML:  "text/html; charset=utf-8",
+}
+
+// ContentType returns the mime type of the job output
+func (r *Job) ContentType() string {
+	if r.Paginate && r.IsImage() {
+		return ContentTypeZip
+	}
+	if ct, ok := contentTypes[r.OutputFormat]; ok {
+		return ct
+	}
+	return contentTypes[OutputPDF]
+}
+
+// IsImage returns true if the job output is a screenshot
+func (r *Job) IsImage() bool {
+	return r.OutputFormat == OutputPNG || r.OutputFormat == OutputJPEG || r.OutputFormat == OutputWebP
+}
+
+// ToViewport returns the device metrics used for screenshots
+func (r *Job) ToViewport() *proto.EmulationSetDeviceMetricsOverride {
+	return &proto.EmulationSetDeviceMetricsOverride{
+		Width:             r.ViewportWidth,
+		Height:            r.ViewportHeight,
+		DeviceScaleFactor: r.DeviceScaleFactor,
+		Mobile:            false,
+	}
+}
+
+// ToScreenshotOptions returns the screenshot request, without clip region
+func (r *Job) ToScreenshotOptions() *proto.PageCaptureScreenshot {
+	req := &proto.PageCaptureScreenshot{
+		Format: proto.PageCaptureScreenshotFormatPng,
+	}
+	switch r.OutputFormat {
+	case OutputJPEG:
+		req.Format = proto.PageCaptureScreenshotFormatJpeg
+		req.Quality = &r.Quality
+	case OutputWebP:
+		req.Format = proto.PageCaptureScreenshotFormatWebp
+		req.Quality = &r.Quality
+	}
+	return req
+}
+
+// calcPageSizePx returns the size of a printed page, including margins, in CSS pixels
+func (r *Job) calcPageSizePx() (int, int) {
+	width, height := r.calcPaperSize()
+	w, h := *width, *height
+	if r.Landscape {
+		w, h = h, w
+	}
+	return int(math.Round(w * CSSPixelsPerInch)), int(math.Round(h * CSSPixelsPerInch))
+}
+
+// preparePage injects the job data, and sets viewport and background for image output; it must be called before navigation
+// Paginated output is printed as in PDF output, so the page is left as is
+func (r *Job) preparePage(page *rod.Page) error {
+	if len(r.Data) > 0 {
+		if _, err := page.EvalOnNewDocument(r.dataScript()); err != nil {
+			return err
+		}
+	}
+	if !r.IsImage() || r.Paginate {
+		return nil
+	}
+	viewport := r.ToViewport()
+	if err := page.SetViewport(viewport); err != nil {
+		return err
+	}
+	// jpeg has no alpha channel
+	if r.TransparentBackground && r.OutputFormat != OutputJPEG {
+		return proto.EmulationSetDefaultBackgroundColorOverride{
+			Color: &proto.DOMRGBA{R: 0, G: 0, B: 0, A: wrap(0)},
+		}.Call(page)
+	}
+	return nil
+}
+
+// renderOutput generates the job output from the loaded page
+func (r *Job) renderOutput(page *rod.Page) ([]byte, error) {
+	if r.IsSnapshot() {
+		return r.snapshot(page)
+	}
+	if !r.IsImage() {
+		pdf, err := page.PDF(r.ToPDFOptions())
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(pdf)
+	}
+	if r.Paginate {
+		return r.pageImages(page)
+	}
+	return r.screenshot(page)
+}
+
+// pageImages prints the page as in PDF output, and rasterizes each PDF page with the browser PDF viewer, in a second
+// tab; headers and footers, margins, backgrounds and page break rules are those of the PDF. Returns a zip archive
+// with page-001.<ext>, page-002.<ext>...; fails with ErrTooManyPages if the PDF exceeds JobMaxPages, instead of
+// returning a truncated archive
+func (r *Job) pageImages(page *rod.Page) ([]byte, error) {
+	stream, err := page.PDF(r.ToPDFOptions())
+	if err != nil {
+		return nil, err
+	}
+	pdf, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	pages := pdfPageCount(pdf)
+	if pages == 0 {
+		return nil, errNoPdfPages
+	}
+	if pages > JobMaxPages {
+		return nil, fmt.Errorf("%w: %d pages", ErrTooManyPages, pages)
+	}
+
+	// the viewer loads the PDF from a temporary file, as the browser runs on the same host
+	f, err := os.CreateTemp("", "zipreport-pages-*.pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, err = f.Write(pdf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	pdfURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(f.Name())}).String()
+
+	ctx := page.GetContext()
+	tab, err := page.Browser().Context(ctx).Page(proto.TargetCreateTarget{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tab.Close() }()
+	width, height := r.calcPageSizePx()
+	err = tab.SetViewport(&proto.EmulationSetDeviceMetricsOverride{
+		Width:             width,
+		Height:            height,
+		DeviceScaleFactor: r.DeviceScaleFactor,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for i := 1; i <= pages; i++ {
+		// the viewer reads the page parameter when the document is loaded
+		if err = tab.Navigate("about:blank"); err != nil {
+			return nil, err
+		}
+		if err = tab.Navigate(fmt.Sprintf("%s#page=%d&%s", pdfURL, i, pdfViewerParams)); err != nil {
+			return nil, err
+		}
+		if err = tab.WaitLoad(); err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(pdfRenderDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		shot, err := r.ToScreenshotOptions().Call(tab)
+		if err != nil {
+			return nil, err
+		}
+		w, err := zw.Create(fmt.Sprintf("page-%03d.%s", i, r.OutputFormat))
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(shot.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfPageCount returns the number of pages of a PDF generated by the browser
+func pdfPageCount(pdf []byte) int {
+	return len(pdfPageRegex.FindAll(pdf, -1))
+}
+
+// ToCaptureClip returns the full-page capture area for a page of the given size, in CSS pixels
+// The image is scaled by DeviceScaleFactor, so each dimension is capped at JobMaxViewportSize / DeviceScaleFactor
+func (r *Job) ToCaptureClip(width float64, height float64) *proto.PageViewport {
+	scale := r.DeviceScaleFactor
+	if scale <= 0 {
+		scale = JobDefaultDeviceScale
+	}
+	limit := math.Floor(JobMaxViewportSize / scale)
+	return &proto.PageViewport{
+		X:      0,
+		Y:      0,
+		Width:  math.Min(width, limit),
+		Height: math.Min(height, limit),
+		Scale:  1,
+	}
+}
+
+// screenshot captures either the viewport or the whole page
+func (r *Job) screenshot(page *rod.Page) ([]byte, error) {
+	req := r.ToScreenshotOptions()
+	if r.FullPage {
+		metrics, err := proto.PageGetLayoutMetrics{}.Call(page)
+		if err != nil {
+			return nil, err
+		}
+		width, height := float64(r.ViewportWidth), float64(r.ViewportHeight)
+		if metrics.CSSContentSize != nil {
+			width = math.Max(width, metrics.CSSContentSize.Width)
+			height = math.Max(height, metrics.CSSContentSize.Height)
+		}
+		req.CaptureBeyondViewport = true
+		req.Clip = r.ToCaptureClip(width, height)
+	}
+	shot, err := req.Call(page)
+	if err != nil {
+		return nil, err
+	}
+	return shot.Data, nil
+}
@@ -0,0 +1,87 @@
+package render
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// PaperSize holds named paper dimensions, in inches
+type PaperSize struct {
+	Width  float64
+	Height float64
+}
+
+var ErrInvalidPaperSizeName = errors.New("invalid paper size name")
+
+var paperMx sync.RWMutex
+
+// built-in paper sizes; additional sizes can be registered from the server configuration
+var paperSizes = map[string]PaperSize{
+	PageA3:      {Width: 11.7, Height: 16.5},
+	PageA4:      {Width: 8.3, Height: 11.7},
+	PageA5:      {Width: 5.8, Height: 8.3},
+	PageLetter:  {Width: 8.5, Height: 11},
+	PageLegal:   {Width: 8.5, Height: 14},
+	PageTabloid: {Width: 11, Height: 17},
+}
+
+// pageSizeNames lists the accepted page_size values, including registered paper sizes; guarded by paperMx
+var pageSizeNames = buildPageSizeList()
+
+// ValidPageSizes lists the accepted page_size values, including registered paper sizes
+// It is only updated by RegisterPaperSize() during startup; do not modify directly
+//
+// Deprecated: use PageSizeNames(), which is safe for concurrent use
+var ValidPageSizes = slices.Clone(pageSizeNames)
+
+// RegisterPaperSize adds or replaces a named paper size, with dimensions in inches
+// Registration is expected to happen during startup, before any job is rendered
+func RegisterPaperSize(name string, width float64, height float64) error {
+	if len(name) == 0 || name == PageCustom {
+		return ErrInvalidPaperSizeName
+	}
+	if width < MinPaperSize || width > MaxPaperSize || height < MinPaperSize || height > MaxPaperSize {
+		return ErrInvalidPaperSize
+	}
+	paperMx.Lock()
+	defer paperMx.Unlock()
+	paperSizes[name] = PaperSize{Width: width, Height: height}
+	pageSizeNames = buildPageSizeList()
+	ValidPageSizes = slices.Clone(pageSizeNames)
+	return nil
+}
+
+// GetPaperSize returns the dimensions of a named paper size
+func GetPaperSize(name string) (PaperSize, bool) {
+	paperMx.RLock()
+	defer paperMx.RUnlock()
+	size, ok := paperSizes[name]
+	return size, ok
+}
+
+// IsValidPageSize returns true if name is a registered paper size or PageCustom
+func IsValidPageSize(name string) bool {
+	if name == PageCustom {
+		return true
+	}
+	_, ok := GetPaperSize(name)
+	return ok
+}
+
+// PageSizeNames returns the accepted page_size values, including registered paper sizes
+func PageSizeNames() []string {
+	paperMx.RLock()
+	defer paperMx.RUnlock()
+	return slices.Clone(pageSizeNames)
+}
+
+func buildPageSizeList() []string {
+	result := make([]string, 0, len(paperSizes)+1)
+	for name := range paperSizes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return append(result, PageCustom)
+}
@@ -0,0 +1,215 @@
+package render
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Preset is a named set of render options, defined in the server configuration
+// Empty/nil fields are not applied, and per-request values override preset values
+type Preset struct {
+	PageSize          string   `json:"pageSize"`
+	PageWidth         string   `json:"pageWidth"` // lengths accept an optional unit suffix
+	PageHeight        string   `json:"pageHeight"`
+	Margins           string   `json:"margins"`
+	MarginLeft        string   `json:"marginLeft"`
+	MarginRight       string   `json:"marginRight"`
+	MarginTop         string   `json:"marginTop"`
+	MarginBottom      string   `json:"marginBottom"`
+	Landscape         *bool    `json:"landscape"`
+	SettlingTimeMs    *int     `json:"settlingTime"`
+	JobTimeoutS       *int     `json:"timeoutJob"`
+	JsTimeoutS        *int     `json:"timeoutJs"`
+	JsEvent           *bool    `json:"jsEvent"`
+	JsEventStrict     *bool    `json:"jsEventStrict"`
+	IgnoreSSLErrors   *bool    `json:"ignoreSslErrors"`
+	PrintBackground   *bool    `json:"printBackground"`
+	PreferCSSPageSize *bool    `json:"preferCssPageSize"`
+	HeaderTemplate    string   `json:"headerTemplate"`
+	FooterTemplate    string   `json:"footerTemplate"`
+	HeaderHeight      string   `json:"headerHeight"`
+	FooterHeight      string   `json:"footerHeight"`
+	OutputFormat      string   `json:"outputFormat"`
+	ViewportWidth     *int     `json:"viewportWidth"`
+	ViewportHeight    *int     `json:"viewportHeight"`
+	DeviceScale       *float64 `json:"deviceScale"`
+	Quality           *int     `json:"quality"`
+	FullPage          *bool    `json:"fullPage"`
+	Transparent       *bool    `json:"transparent"`
+	Paginate          *bool    `json:"paginate"`
+	WaitFor           []string `json:"waitFor"` // readiness conditions, in the type[:value] format
+	Priority          string   `json:"priority"`
+}
+
+var ErrInvalidPresetName = errors.New("invalid preset name")
+var ErrInvalidPresetPageSize = errors.New("invalid preset page size")
+var ErrInvalidPresetMargins = errors.New("invalid preset margin style")
+var ErrInvalidPresetOutput = errors.New("invalid preset output format")
+var ErrInvalidPresetPriority = errors.New("invalid preset priority")
+
+var presetMx sync.RWMutex
+var presets = map[string]*Preset{}
+
+// Validate checks preset values; page sizes must be registered beforehand
+func (p *Preset) Validate() error {
+	if len(p.PageSize) > 0 && !IsValidPageSize(p.PageSize) {
+		return ErrInvalidPresetPageSize
+	}
+	if len(p.Margins) > 0 && !slices.Contains(ValidMarginStyle, p.Margins) {
+		return ErrInvalidPresetMargins
+	}
+	if len(p.OutputFormat) > 0 && !slices.Contains(ValidOutputFormats, p.OutputFormat) {
+		return ErrInvalidPresetOutput
+	}
+	if len(p.Priority) > 0 && !slices.Contains(ValidPriority, p.Priority) {
+		return ErrInvalidPresetPriority
+	}
+	if len(p.HeaderTemplate) > MaxTemplateSize || len(p.FooterTemplate) > MaxTemplateSize {
+		return ErrInvalidTemplate
+	}
+	if _, err := ParseWaitConditions(p.WaitFor); err != nil {
+		return err
+	}
+	for _, v := range []string{p.PageWidth, p.PageHeight, p.MarginLeft, p.MarginRight, p.MarginTop, p.MarginBottom, p.HeaderHeight, p.FooterHeight} {
+		if len(v) > 0 {
+			if l, err := ParseLength(v); err != nil || l < 0 {
+				return ErrInvalidLength
+			}
+		}
+	}
+	return nil
+}
+
+// Apply sets the preset values on the job
+func (p *Preset) Apply(job *Job) error {
+	var err error
+	if len(p.PageSize) > 0 {
+		job.PageSize = p.PageSize
+	}
+	if len(p.Margins) > 0 {
+		job.MarginStyle = p.Margins
+	}
+	lengths := []struct {
+		value string
+		dest  *float64
+	}{
+		{p.PageWidth, &job.PageWidth},
+		{p.PageHeight, &job.PageHeight},
+		{p.MarginLeft, &job.MarginLeft},
+		{p.MarginRight, &job.MarginRight},
+		{p.MarginTop, &job.MarginTop},
+		{p.MarginBottom, &job.MarginBottom},
+		{p.HeaderHeight, &job.HeaderHeight},
+		{p.FooterHeight, &job.FooterHeight},
+	}
+	for _, l := range lengths {
+		if len(l.value) > 0 {
+			if *l.dest, err = ParseLength(l.value); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Landscape != nil {
+		job.Landscape = *p.Landscape
+	}
+	if p.SettlingTimeMs != nil {
+		job.JobSettlingTimeMs = *p.SettlingTimeMs
+	}
+	if p.JobTimeoutS != nil {
+		job.JobTimeoutS = *p.JobTimeoutS
+	}
+	if p.JsTimeoutS != nil {
+		job.JsTimeoutS = *p.JsTimeoutS
+	}
+	if p.JsEvent != nil {
+		job.UseJSEvent = *p.JsEvent
+	}
+	if p.JsEventStrict != nil {
+		job.StrictReady = *p.JsEventStrict
+	}
+	if p.IgnoreSSLErrors != nil {
+		job.IgnoreSSLErrors = *p.IgnoreSSLErrors
+	}
+	if p.PrintBackground != nil {
+		job.PrintBackground = *p.PrintBackground
+	}
+	if p.PreferCSSPageSize != nil {
+		job.PreferCSSPageSize = *p.PreferCSSPageSize
+	}
+	if len(p.HeaderTemplate) > 0 {
+		job.HeaderTemplate = p.HeaderTemplate
+	}
+	if len(p.FooterTemplate) > 0 {
+		job.FooterTemplate = p.FooterTemplate
+	}
+	if len(p.OutputFormat) > 0 {
+		job.OutputFormat = p.OutputFormat
+	}
+	if p.ViewportWidth != nil {
+		job.ViewportWidth = *p.ViewportWidth
+	}
+	if p.ViewportHeight != nil {
+		job.ViewportHeight = *p.ViewportHeight
+	}
+	if p.DeviceScale != nil {
+		job.DeviceScaleFactor = *p.DeviceScale
+	}
+	if p.Quality != nil {
+		job.Quality = *p.Quality
+	}
+	if p.FullPage != nil {
+		job.FullPage = *p.FullPage
+	}
+	if p.Transparent != nil {
+		job.TransparentBackground = *p.Transparent
+	}
+	if p.Paginate != nil {
+		job.Paginate = *p.Paginate
+	}
+	if len(p.WaitFor) > 0 {
+		if job.WaitFor, err = ParseWaitConditions(p.WaitFor); err != nil {
+			return err
+		}
+	}
+	if len(p.Priority) > 0 {
+		job.Priority = p.Priority
+	}
+	return nil
+}
+
+// RegisterPreset validates and adds or replaces a named preset
+// Registration is expected to happen during startup, before any job is rendered
+func RegisterPreset(name string, p *Preset) error {
+	if len(name) == 0 || p == nil {
+		return ErrInvalidPresetName
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	presetMx.Lock()
+	defer presetMx.Unlock()
+	presets[name] = p
+	return nil
+}
+
+// GetPreset returns a registered preset
+func GetPreset(name string) (*Preset, bool) {
+	presetMx.RLock()
+	defer presetMx.RUnlock()
+	p, ok := presets[name]
+	return p, ok
+}
+
+// PresetNames returns the sorted list of registered presets
+func PresetNames() []string {
+	presetMx.RLock()
+	defer presetMx.RUnlock()
+	result := make([]string, 0, len(presets))
+	for name := range presets {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,400 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+	"zipreport-server/pkg/monitor"
+)
+
+// Default queue limits, used by the server configuration
+const DefaultQueueDepth = 100
+const DefaultQueueMaxWait = 60 // seconds
+
+// MaxRetryAfter caps the suggested retry delay of rejected jobs, in seconds
+const MaxRetryAfter = 300
+
+// Priority classes; waiting jobs of a higher class are always started first
+const PriorityHigh = "high"
+const PriorityNormal = "normal"
+const PriorityLow = "low"
+
+// DefaultClient is the client name of jobs without a client
+const DefaultClient = "default"
+
+// ValidPriority lists the priority classes, from highest to lowest
+var ValidPriority = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// ClampPriority returns priority, lowered to maxPriority if it is a higher class; an empty maxPriority is
+// unbounded
+func ClampPriority(priority string, maxPriority string) string {
+	limit := slices.Index(ValidPriority, maxPriority)
+	if limit < 0 || slices.Index(ValidPriority, priority) >= limit {
+		return priority
+	}
+	return maxPriority
+}
+
+var ErrQueueFull = errors.New("render queue is full")
+var ErrClientQueueFull = fmt.Errorf("%w for this client", ErrQueueFull)
+var ErrQueueTimeout = errors.New("timed out waiting for a render slot")
+var ErrTicketReleased = errors.New("render queue ticket already released")
+
+// QueueError is a job rejected by the render queue, with the suggested retry delay
+type QueueError struct {
+	Reason     error // ErrQueueFull, ErrClientQueueFull or ErrQueueTimeout
+	RetryAfter int   // seconds
+}
+
+func (e *QueueError) Error() string {
+	return e.Reason.Error()
+}
+
+func (e *QueueError) Unwrap() error {
+	return e.Reason
+}
+
+/**
+ * Queue limits the number of jobs waiting for one of the render slots, overall and per client, and how long they wait
+ * Free slots are given to the highest priority class with waiting jobs; within a class, clients are served
+ * in weighted round robin, so a client with weight N starts up to N jobs per turn
+ */
+type Queue struct {
+	mx       sync.Mutex
+	free     int // free render slots
+	size     int
+	waiting  int // jobs holding a ticket, not started yet
+	classes  map[string]*priorityClass
+	weights  map[string]int // client weights, 1 if not set
+	depths   map[string]int // client max queue depths, unbounded if not set
+	clients  map[string]int // waiting jobs per client
+	maxDepth int            // 0 is unbounded
+	maxWait  time.Duration  // 0 is unbounded
+	avgHold  float64        // moving average of slot usage, in seconds
+	metrics  *monitor.Metrics
+}
+
+// priorityClass holds the waiting tickets of a priority, per client
+type priorityClass struct {
+	clients map[string]*clientQueue
+	ring    []string // round robin order; only clients with waiting tickets
+	next    int      // ring position of the client being served
+}
+
+type clientQueue struct {
+	tickets []*Ticket
+	credit  int // jobs left to start in the current turn
+}
+
+// Ticket is a place in the queue; Wait acquires a render slot, and Release frees it
+type Ticket struct {
+	mx         sync.Mutex
+	q          *Queue
+	maxWait    time.Duration
+	priority   string
+	client     string
+	ready      chan struct{} // closed when a slot is granted
+	queued     bool          // counted as waiting
+	granted    bool          // slot granted by the scheduler; guarded by the queue lock
+	acquired   bool          // holding a slot
+	acquiredAt time.Time
+}
+
+// NewQueue creates an unbounded queue for size render slots
+func NewQueue(size int, m *monitor.Metrics) *Queue {
+	classes := make(map[string]*priorityClass, len(ValidPriority))
+	for _, p := range ValidPriority {
+		classes[p] = &priorityClass{clients: make(map[string]*clientQueue)}
+	}
+	return &Queue{
+		free:    size,
+		size:    size,
+		classes: classes,
+		weights: make(map[string]int),
+		depths:  make(map[string]int),
+		clients: make(map[string]int),
+		metrics: m,
+	}
+}
+
+// SetLimits sets the maximum queue depth and wait time; zero values are unbounded
+func (q *Queue) SetLimits(maxDepth int, maxWait time.Duration) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.maxDepth = maxDepth
+	q.maxWait = maxWait
+}
+
+// Limits returns the maximum queue depth and wait time; zero values are unbounded
+func (q *Queue) Limits() (maxDepth int, maxWait time.Duration) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.maxDepth, q.maxWait
+}
+
+// SetClientWeight sets the share of render slots of a client, relative to other clients of the same priority
+func (q *Queue) SetClientWeight(client string, weight int) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.weights[client] = max(1, weight)
+}
+
+// SetClientMaxDepth sets the maximum number of waiting jobs of a client; 0 is only bounded by the queue depth
+func (q *Queue) SetClientMaxDepth(client string, maxDepth int) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.depths[client] = max(0, maxDepth)
+}
+
+// Depth returns the number of jobs waiting for a render slot
+func (q *Queue) Depth() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.waiting
+}
+
+// Stats returns the number of render slots, free slots and waiting jobs
+func (q *Queue) Stats() (size int, free int, waiting int) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.size, q.free, q.waiting
+}
+
+// Reserve takes a place in the queue for client, or fails with a QueueError if the queue, or the share of the
+// client, is full. The ticket waits for a render slot up to the queue max wait
+func (q *Queue) Reserve(client string) (*Ticket, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.reserve(client, false, q.maxWait)
+}
+
+// ReserveBackground takes a place in the queue for a background job, that waits for a render slot without
+// time limit; if force is true, the queue depths are ignored (e.g. for jobs accepted before a restart)
+func (q *Queue) ReserveBackground(client string, force bool) (*Ticket, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.reserve(client, force, 0)
+}
+
+// reserve must be called with the lock held; empty clients are replaced by DefaultClient
+func (q *Queue) reserve(client string, force bool, maxWait time.Duration) (*Ticket, error) {
+	if len(client) == 0 {
+		client = DefaultClient
+	}
+	if !force && q.maxDepth > 0 && q.waiting >= q.maxDepth {
+		q.metrics.QueueRejected.Inc()
+		return nil, &QueueError{Reason: ErrQueueFull, RetryAfter: q.retryAfter()}
+	}
+	if limit := q.depths[client]; !force && limit > 0 && q.clients[client] >= limit {
+		q.metrics.QueueRejected.Inc()
+		return nil, &QueueError{Reason: ErrClientQueueFull, RetryAfter: q.retryAfter()}
+	}
+	q.waiting++
+	q.clients[client]++
+	q.metrics.QueueDepth.Set(float64(q.waiting))
+	return &Ticket{
+		q:        q,
+		maxWait:  maxWait,
+		priority: PriorityNormal,
+		client:   client,
+		ready:    make(chan struct{}),
+		queued:   true,
+	}, nil
+}
+
+// retryAfter estimates when a slot is available for a new job; must be called with the lock held
+func (q *Queue) retryAfter() int {
+	est := math.Ceil(float64(q.waiting+1) / float64(q.size) * q.avgHold)
+	return int(max(1, min(est, MaxRetryAfter)))
+}
+
+// leave removes a ticket from the waiting jobs; must be called with the lock held
+func (q *Queue) leave(t *Ticket) {
+	q.waiting--
+	if q.clients[t.client]--; q.clients[t.client] <= 0 {
+		delete(q.clients, t.client)
+	}
+	q.metrics.QueueDepth.Set(float64(q.waiting))
+}
+
+// dispatch grants the free slots to the waiting tickets; must be called with the lock held
+func (q *Queue) dispatch() {
+	for _, p := range ValidPriority {
+		class := q.classes[p]
+		for q.free > 0 {
+			t := class.pop(q.weights)
+			if t == nil {
+				break
+			}
+			q.free--
+			t.granted = true
+			close(t.ready)
+			q.metrics.PriorityQueueDepth.WithLabelValues(p).Dec()
+		}
+	}
+}
+
+func (c *priorityClass) push(t *Ticket) {
+	cq, ok := c.clients[t.client]
+	if !ok {
+		cq = &clientQueue{}
+		c.clients[t.client] = cq
+		c.ring = append(c.ring, t.client)
+	}
+	cq.tickets = append(cq.tickets, t)
+}
+
+// pop returns the next ticket in weighted round robin order, or nil if there are no waiting tickets
+func (c *priorityClass) pop(weights map[string]int) *Ticket {
+	if len(c.ring) == 0 {
+		return nil
+	}
+	if c.next >= len(c.ring) {
+		c.next = 0
+	}
+	client := c.ring[c.next]
+	cq := c.clients[client]
+	if cq.credit <= 0 {
+		cq.credit = max(1, weights[client])
+	}
+	t := cq.tickets[0]
+	cq.tickets = cq.tickets[1:]
+	cq.credit--
+	switch {
+	case len(cq.tickets) == 0:
+		c.removeClient(c.next)
+	case cq.credit == 0:
+		c.next++
+	}
+	return t
+}
+
+// remove removes a ticket that stopped waiting
+func (c *priorityClass) remove(t *Ticket) {
+	cq, ok := c.clients[t.client]
+	if !ok {
+		return
+	}
+	for i, item := range cq.tickets {
+		if item == t {
+			cq.tickets = append(cq.tickets[:i], cq.tickets[i+1:]...)
+			break
+		}
+	}
+	if len(cq.tickets) > 0 {
+		return
+	}
+	for i, client := range c.ring {
+		if client == t.client {
+			c.removeClient(i)
+			return
+		}
+	}
+}
+
+// removeClient removes the client at ring position i; the next position is kept on the following client
+func (c *priorityClass) removeClient(i int) {
+	delete(c.clients, c.ring[i])
+	c.ring = append(c.ring[:i], c.ring[i+1:]...)
+	if i < c.next {
+		c.next--
+	}
+}
+
+// Assign sets the priority class of the ticket; must be called before Wait
+// Unknown priorities are replaced by PriorityNormal
+func (t *Ticket) Assign(priority string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if _, ok := t.q.classes[priority]; ok {
+		t.priority = priority
+	}
+}
+
+// Wait blocks until a render slot is available, the max wait is exceeded, or ctx is done
+func (t *Ticket) Wait(ctx context.Context) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if !t.queued {
+		if t.acquired {
+			return nil
+		}
+		return ErrTicketReleased
+	}
+	start := time.Now()
+	q := t.q
+	q.mx.Lock()
+	q.classes[t.priority].push(t)
+	q.metrics.PriorityQueueDepth.WithLabelValues(t.priority).Inc()
+	q.dispatch()
+	q.mx.Unlock()
+
+	var timeout <-chan time.Time
+	if t.maxWait > 0 {
+		timer := time.NewTimer(t.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-t.ready:
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	t.queued = false
+	q.leave(t)
+	if t.granted {
+		// granted while timing out, the slot is used anyway
+		err = nil
+		t.acquired = true
+		t.acquiredAt = time.Now()
+	} else {
+		q.classes[t.priority].remove(t)
+		q.metrics.PriorityQueueDepth.WithLabelValues(t.priority).Dec()
+		if errors.Is(err, ErrQueueTimeout) {
+			q.metrics.QueueRejected.Inc()
+			err = &QueueError{Reason: ErrQueueTimeout, RetryAfter: q.retryAfter()}
+		}
+	}
+	wait := time.Since(start).Seconds()
+	q.metrics.QueueWaitTime.Observe(wait)
+	q.metrics.PriorityWaitTime.WithLabelValues(t.priority).Observe(wait)
+	if t.acquired {
+		q.metrics.PriorityJobs.WithLabelValues(t.priority).Inc()
+	}
+	return err
+}
+
+// Release frees the render slot, or the place in the queue if no slot was acquired; can be called more than once
+func (t *Ticket) Release() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	q := t.q
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if t.queued {
+		t.queued = false
+		q.leave(t)
+	}
+	if t.acquired {
+		t.acquired = false
+		held := time.Since(t.acquiredAt).Seconds()
+		if q.avgHold == 0 {
+			q.avgHold = held
+		} else {
+			q.avgHold = 0.8*q.avgHold + 0.2*held
+		}
+		q.free++
+		q.dispatch()
+	}
+}
@@ -0,0 +1,108 @@
+package render
+
+import (
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// inlineScript converts the rendered DOM into a self-contained html document:
+// canvas elements are replaced with images, images and stylesheets (including url() references) are
+// inlined as data urls, and scripts are removed, so the document is not re-rendered when opened
+const inlineScript = `async () => {
+	const toDataUrl = async (url) => {
+		try {
+			const resp = await fetch(url);
+			if (!resp.ok) {
+				return url;
+			}
+			const blob = await resp.blob();
+			return await new Promise((resolve) => {
+				const reader = new FileReader();
+				reader.onload = () => resolve(reader.result);
+				reader.onerror = () => resolve(url);
+				reader.readAsDataURL(blob);
+			});
+		} catch (e) {
+			return url;
+		}
+	};
+	const inlineCss = async (css, baseUrl) => {
+		const refs = [...css.matchAll(/url\(\s*(['"]?)([^'")]+)\1\s*\)/g)];
+		for (const ref of refs) {
+			if (ref[2].startsWith("data:")) {
+				continue;
+			}
+			const data = await toDataUrl(new URL(ref[2], baseUrl).href);
+			css = css.split(ref[0]).join('url("' + data + '")');
+		}
+		return css;
+	};
+
+	for (const canvas of Array.from(document.querySelectorAll("canvas"))) {
+		try {
+			const img = document.createElement("img");
+			img.src = canvas.toDataURL("image/png");
+			img.width = canvas.width;
+			img.height = canvas.height;
+			img.className = canvas.className;
+			img.style.cssText = canvas.style.cssText;
+			if (canvas.id) {
+				img.id = canvas.id;
+			}
+			canvas.replaceWith(img);
+		} catch (e) {
+			// tainted canvas, keep as-is
+		}
+	}
+	for (const img of Array.from(document.querySelectorAll("img[src]"))) {
+		if (!img.src.startsWith("data:")) {
+			img.removeAttribute("srcset");
+			img.src = await toDataUrl(img.src);
+		}
+	}
+	for (const link of Array.from(document.querySelectorAll('link[rel~="stylesheet"][href]'))) {
+		try {
+			const resp = await fetch(link.href);
+			if (!resp.ok) {
+				continue;
+			}
+			const style = document.createElement("style");
+			if (link.media) {
+				style.media = link.media;
+			}
+			style.textContent = await inlineCss(await resp.text(), link.href);
+			link.replaceWith(style);
+		} catch (e) {
+			// keep external reference
+		}
+	}
+	for (const style of Array.from(document.querySelectorAll("style"))) {
+		style.textContent = await inlineCss(style.textContent, document.baseURI);
+	}
+	for (const script of Array.from(document.querySelectorAll("script"))) {
+		script.remove();
+	}
+	const doctype = document.doctype ? new XMLSerializer().serializeToString(document.doctype) + "\n" : "";
+	return doctype + document.documentElement.outerHTML;
+}`
+
+// IsSnapshot returns true if the job output is a DOM snapshot (mhtml or html)
+func (r *Job) IsSnapshot() bool {
+	return r.OutputFormat == OutputMHTML || r.OutputFormat == OutputHTML
+}
+
+// snapshot serializes the rendered page
+func (r *Job) snapshot(page *rod.Page) ([]byte, error) {
+	if r.OutputFormat == OutputMHTML {
+		result, err := proto.PageCaptureSnapshot{Format: proto.PageCaptureSnapshotFormatMhtml}.Call(page)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(result.Data), nil
+	}
+	result, err := page.Eval(inlineScript)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(result.Value.Str()), nil
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// ErrorConsoleMessage is the console message used by templates to abort the job
+// An optional second argument carries a {"message": "...", "code": "..."} payload, either as an object or a JSON string
+const ErrorConsoleMessage = "zpt-view-error"
+
+const TemplateErrorDefaultCode = "template_error"
+const TemplateErrorDefaultMessage = "template signalled a render error"
+const TemplateErrorMaxMessage = 1024
+const TemplateErrorMaxCode = 64
+
+var templateErrorCode = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// TemplateError is a render failure signalled by the template
+type TemplateError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *TemplateError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newTemplateError builds a TemplateError from the optional console payload; invalid
+// or oversized values are replaced by defaults, as they are returned to the client
+func newTemplateError(page *rod.Page, args []*proto.RuntimeRemoteObject) *TemplateError {
+	result := &TemplateError{}
+	if len(args) > 1 {
+		if val, err := page.ObjectToJSON(args[1]); err == nil {
+			raw := val.JSON("", "")
+			if s, ok := val.Val().(string); ok {
+				raw = s
+			}
+			if err = json.Unmarshal([]byte(raw), result); err != nil {
+				// plain string payload
+				result.Message = raw
+			}
+		}
+	}
+	if len(result.Code) > TemplateErrorMaxCode || !templateErrorCode.MatchString(result.Code) {
+		result.Code = TemplateErrorDefaultCode
+	}
+	if len(result.Message) == 0 {
+		result.Message = TemplateErrorDefaultMessage
+	}
+	if len(result.Message) > TemplateErrorMaxMessage {
+		result.Message = strings.ToValidUTF8(result.Message[:TemplateErrorMaxMessage], "")
+	}
+	return result
+}
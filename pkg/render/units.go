@@ -0,0 +1,54 @@
+package render
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Length units; values without unit are in inches
+const UnitInch = "in"
+const UnitCentimeter = "cm"
+const UnitMillimeter = "mm"
+const UnitPixel = "px"
+const UnitPoint = "pt"
+
+// Paper size bounds, in inches
+// PDF pages cannot exceed 14400pt (200in) in either dimension
+const MinPaperSize = 0.1
+const MaxPaperSize = 200
+
+var ErrInvalidLength = errors.New("invalid length value")
+var ErrInvalidPaperSize = errors.New("invalid paper size")
+var ErrInvalidMargins = errors.New("margins exceed paper size")
+
+// conversion factor to inches
+var unitFactors = map[string]float64{
+	UnitInch:       1,
+	UnitCentimeter: 1 / 2.54,
+	UnitMillimeter: 1 / 25.4,
+	UnitPixel:      1.0 / 96,
+	UnitPoint:      1.0 / 72,
+}
+
+var ValidUnits = []string{UnitInch, UnitCentimeter, UnitMillimeter, UnitPixel, UnitPoint}
+
+// ParseLength parses a length with an optional unit suffix (e.g. "10mm", "0.5in", "2")
+// and returns the value in inches; values without unit are considered to be inches
+func ParseLength(v string) (float64, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	factor := 1.0
+	for unit, f := range unitFactors {
+		if strings.HasSuffix(v, unit) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, unit))
+			factor = f
+			break
+		}
+	}
+	result, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, ErrInvalidLength
+	}
+	return result * factor, nil
+}
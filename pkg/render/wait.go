@@ -0,0 +1,353 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Wait condition types
+const WaitConsole = "console"          // console message equal to the value
+const WaitSelector = "selector"        // CSS selector matches an element
+const WaitExpression = "expr"          // JS expression becomes truthy
+const WaitPromise = "promise"          // window.zptReadyPromise resolves
+const WaitNetworkIdle = "network_idle" // no network activity for <value> ms
+const WaitFonts = "fonts"              // document.fonts.ready resolves
+
+// ReadyConsoleMessage is the console message used by js_event
+const ReadyConsoleMessage = "zpt-view-ready"
+
+const WaitDefaultNetworkIdle = 500 // milliseconds
+const WaitMaxNetworkIdle = 30000   // milliseconds
+const WaitMaxConditions = 16
+const WaitMaxValueSize = 4 << 10 // 4 KiB
+
+// interval between checks of polled conditions
+const waitPollInterval = 50 * time.Millisecond
+
+var ValidWaitConditions = []string{WaitConsole, WaitSelector, WaitExpression, WaitPromise, WaitNetworkIdle, WaitFonts}
+
+var ErrInvalidWaitCondition = errors.New("invalid wait condition")
+var ErrReadyTimeout = errors.New("timed out waiting for page to be ready")
+
+// server-wide default for Job.StrictReady
+var strictReadyDefault atomic.Bool
+
+// SetStrictReadyDefault sets the default StrictReady value for new jobs
+func SetStrictReadyDefault(strict bool) {
+	strictReadyDefault.Store(strict)
+}
+
+// WaitCondition is a readiness condition, evaluated after the page is loaded
+type WaitCondition struct {
+	Type  string
+	Value string
+}
+
+// ParseWaitCondition parses a condition in the "type[:value]" format
+// console, selector and expr require a value; network_idle accepts an optional
+// idle time in milliseconds; promise and fonts take no value
+func ParseWaitCondition(s string) (WaitCondition, error) {
+	condType, value, hasValue := strings.Cut(strings.TrimSpace(s), ":")
+	cond := WaitCondition{Type: condType, Value: value}
+	if len(value) > WaitMaxValueSize {
+		return cond, ErrInvalidWaitCondition
+	}
+	switch condType {
+	case WaitConsole, WaitSelector, WaitExpression:
+		if len(strings.TrimSpace(value)) == 0 {
+			return cond, ErrInvalidWaitCondition
+		}
+	case WaitPromise, WaitFonts:
+		if hasValue {
+			return cond, ErrInvalidWaitCondition
+		}
+	case WaitNetworkIdle:
+		if !hasValue {
+			cond.Value = strconv.Itoa(WaitDefaultNetworkIdle)
+			break
+		}
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 || ms > WaitMaxNetworkIdle {
+			return cond, ErrInvalidWaitCondition
+		}
+	default:
+		return cond, ErrInvalidWaitCondition
+	}
+	return cond, nil
+}
+
+// ParseWaitConditions parses a list of conditions; all of them must be met for the page to be ready
+func ParseWaitConditions(list []string) ([]WaitCondition, error) {
+	if len(list) > WaitMaxConditions {
+		return nil, ErrInvalidWaitCondition
+	}
+	result := make([]WaitCondition, 0, len(list))
+	for _, s := range list {
+		cond, err := ParseWaitCondition(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, cond)
+	}
+	return result, nil
+}
+
+func (c WaitCondition) String() string {
+	if len(c.Value) == 0 {
+		return c.Type
+	}
+	return c.Type + ":" + c.Value
+}
+
+// ReadyConditions returns the effective wait conditions for the job; js_event adds
+// a wait for the default console message
+func (r *Job) ReadyConditions() []WaitCondition {
+	result := make([]WaitCondition, 0, len(r.WaitFor)+1)
+	if r.UseJSEvent {
+		result = append(result, WaitCondition{Type: WaitConsole, Value: ReadyConsoleMessage})
+	}
+	return append(result, r.WaitFor...)
+}
+
+// waitScript polls in-page conditions; the returned promise resolves when the condition is met
+const waitScript = `(type, value) => new Promise((resolve, reject) => {
+	const poll = (check) => {
+		const run = () => {
+			let ok = false;
+			try {
+				ok = check();
+			} catch (e) {
+				ok = false;
+			}
+			if (ok) {
+				resolve(true);
+			} else {
+				setTimeout(run, %d);
+			}
+		};
+		run();
+	};
+	switch (type) {
+	case "selector":
+		document.querySelector(value); // throws on invalid selectors
+		poll(() => document.querySelector(value) !== null);
+		break;
+	case "expr": {
+		const fn = new Function("return (" + value + ");");
+		poll(() => !!fn());
+		break;
+	}
+	case "promise": {
+		const run = () => {
+			if (window.zptReadyPromise === undefined) {
+				setTimeout(run, %d);
+				return;
+			}
+			Promise.resolve(window.zptReadyPromise).then(() => resolve(true), (e) => reject(new Error("zptReadyPromise rejected: " + e)));
+		};
+		run();
+		break;
+	}
+	case "fonts":
+		document.fonts.ready.then(() => resolve(true), reject);
+		break;
+	default:
+		reject(new Error("unsupported wait condition: " + type));
+	}
+})`
+
+// readyWaiter tracks console messages and network activity for a page, and
+// waits for a set of readiness conditions
+type readyWaiter struct {
+	conditions []WaitCondition
+	mx         sync.Mutex
+	tokens     map[string]chan struct{} // pending console tokens
+	inflight   map[proto.NetworkRequestID]struct{}
+	lastActive time.Time
+	failed     chan struct{} // closed when the template signals an error
+	failure    error
+}
+
+func newReadyWaiter(conditions []WaitCondition) *readyWaiter {
+	w := &readyWaiter{
+		conditions: conditions,
+		tokens:     make(map[string]chan struct{}),
+		inflight:   make(map[proto.NetworkRequestID]struct{}),
+		lastActive: time.Now(),
+		failed:     make(chan struct{}),
+	}
+	for _, c := range conditions {
+		if c.Type == WaitConsole {
+			w.tokens[c.Value] = make(chan struct{})
+		}
+	}
+	return w
+}
+
+// tracksNetwork returns true if network events are required
+func (w *readyWaiter) tracksNetwork() bool {
+	for _, c := range w.conditions {
+		if c.Type == WaitNetworkIdle {
+			return true
+		}
+	}
+	return false
+}
+
+// consoleMessage flags a pending console token as received; returns true if msg was a token
+func (w *readyWaiter) consoleMessage(msg string) bool {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if ch, ok := w.tokens[msg]; ok {
+		close(ch)
+		delete(w.tokens, msg)
+		return true
+	}
+	return false
+}
+
+// fail aborts the wait with err; returns false if the waiter had already failed
+func (w *readyWaiter) fail(err error) bool {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if w.failure != nil {
+		return false
+	}
+	w.failure = err
+	close(w.failed)
+	return true
+}
+
+// failureOr returns the failure error if the waiter failed, or err otherwise
+func (w *readyWaiter) failureOr(err error) error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if w.failure != nil {
+		return w.failure
+	}
+	return err
+}
+
+func (w *readyWaiter) requestStarted(id proto.NetworkRequestID) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	w.inflight[id] = struct{}{}
+	w.lastActive = time.Now()
+}
+
+func (w *readyWaiter) requestFinished(id proto.NetworkRequestID) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	delete(w.inflight, id)
+	w.lastActive = time.Now()
+}
+
+// networkIdleFor returns true if no requests were in flight for at least d
+func (w *readyWaiter) networkIdleFor(d time.Duration) bool {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return len(w.inflight) == 0 && time.Since(w.lastActive) >= d
+}
+
+// networkHandlers returns the event handlers used to track network activity
+func (w *readyWaiter) networkHandlers() []interface{} {
+	return []interface{}{
+		func(evt *proto.NetworkRequestWillBeSent) {
+			w.requestStarted(evt.RequestID)
+		},
+		func(evt *proto.NetworkLoadingFinished) {
+			w.requestFinished(evt.RequestID)
+		},
+		func(evt *proto.NetworkLoadingFailed) {
+			w.requestFinished(evt.RequestID)
+		},
+	}
+}
+
+// wait blocks until all conditions are met, one of them fails, the template signals an error, or ctx is done
+// Returns ErrReadyTimeout if ctx expires first
+func (w *readyWaiter) wait(ctx context.Context, page *rod.Page) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(w.conditions))
+	for _, c := range w.conditions {
+		go func(c WaitCondition) {
+			errs <- w.waitCondition(ctx, page, c)
+		}(c)
+	}
+	for range w.conditions {
+		select {
+		case err := <-errs:
+			if err != nil {
+				return w.failureOr(err)
+			}
+		case <-w.failed:
+			return w.failureOr(nil)
+		case <-ctx.Done():
+			return w.failureOr(ErrReadyTimeout)
+		}
+	}
+	return nil
+}
+
+func (w *readyWaiter) waitCondition(ctx context.Context, page *rod.Page, c WaitCondition) error {
+	switch c.Type {
+	case WaitConsole:
+		w.mx.Lock()
+		ch, pending := w.tokens[c.Value]
+		w.mx.Unlock()
+		if !pending {
+			return nil
+		}
+		select {
+		case <-ch:
+			return nil
+		case <-ctx.Done():
+			return ErrReadyTimeout
+		}
+
+	case WaitNetworkIdle:
+		ms, _ := strconv.Atoi(c.Value)
+		idle := time.Duration(ms) * time.Millisecond
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		for !w.networkIdleFor(idle) {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ErrReadyTimeout
+			}
+		}
+		return nil
+
+	default:
+		interval := waitPollInterval.Milliseconds()
+		_, err := page.Context(ctx).Eval(fmt.Sprintf(waitScript, interval, interval), c.Type, c.Value)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ErrReadyTimeout
+			}
+			return fmt.Errorf("wait condition %s failed: %w", c.Type, err)
+		}
+		return nil
+	}
+}
+
+// waitForNames returns the wait conditions as strings, for logging purposes
+func (r *Job) waitForNames() []string {
+	result := make([]string, 0, len(r.WaitFor))
+	for _, c := range r.WaitFor {
+		result = append(result, c.String())
+	}
+	return result
+}
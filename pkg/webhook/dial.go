@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenAddress = errors.New("callback address not allowed")
+
+// forbiddenIP returns true for addresses callbacks must not reach: loopback (including the per-job report
+// servers), private, link-local (including cloud metadata endpoints), multicast and unspecified addresses
+func forbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkAddress is a net.Dialer Control hook, rejecting connections to forbidden addresses once the host is
+// resolved, so DNS names pointing to internal addresses are rejected too
+func checkAddress(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || forbiddenIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// allowedHost returns true if host is in the configured allowlist, and may resolve to any address
+func (c *Config) allowedHost(host string) bool {
+	return slices.ContainsFunc(c.AllowedHosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, host)
+	})
+}
+
+// newClient returns the callback http client: redirects are not followed, proxies are not used, and only
+// allowlisted hosts may resolve to forbidden addresses
+func newClient(cfg *Config) *http.Client {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	restricted := &net.Dialer{Timeout: timeout, Control: checkAddress}
+	unrestricted := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			if cfg.allowedHost(host) {
+				return unrestricted.DialContext(ctx, network, address)
+			}
+			return restricted.DialContext(ctx, network, address)
+		},
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
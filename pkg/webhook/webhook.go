@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"zipreport-server/pkg/jobs"
+	"zipreport-server/pkg/monitor"
+	"zipreport-server/pkg/render"
+
+	"github.com/oddbit-project/blueprint/log"
+)
+
+// Callback request headers
+const HeaderJobId = "X-Zpt-Job-Id"
+const HeaderStatus = "X-Zpt-Status"
+const HeaderTimestamp = "X-Zpt-Timestamp"
+const HeaderSignature = "X-Zpt-Signature" // sha256=<hex hmac of "<timestamp>.<body>">
+
+// Defaults
+const DefaultMaxAttempts = 5
+const DefaultInitialBackoffMs = 1000
+const DefaultMaxBackoffMs = 60000
+const DefaultTimeoutSeconds = 30
+
+// MaxURLLength caps the size of callback urls
+const MaxURLLength = 2048
+
+var ErrInvalidURL = errors.New("invalid callback url")
+
+// Config holds the webhook delivery settings; callbacks are disabled if Secret is empty
+type Config struct {
+	Secret           string   `json:"secret"`           // HMAC key used to sign callbacks
+	MaxAttempts      int      `json:"maxAttempts"`      // delivery attempts, including the first one
+	InitialBackoffMs int      `json:"initialBackoffMs"` // wait before the first retry, doubled on each retry
+	MaxBackoffMs     int      `json:"maxBackoffMs"`     // upper bound of the wait between retries
+	TimeoutSeconds   int      `json:"timeoutSeconds"`   // timeout of each delivery attempt
+	AllowedHosts     []string `json:"allowedHosts"`     // hosts allowed to resolve to loopback, private or link-local addresses
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Secret:           "",
+		MaxAttempts:      DefaultMaxAttempts,
+		InitialBackoffMs: DefaultInitialBackoffMs,
+		MaxBackoffMs:     DefaultMaxBackoffMs,
+		TimeoutSeconds:   DefaultTimeoutSeconds,
+		AllowedHosts:     []string{},
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.MaxAttempts < 1 {
+		return errors.New("webhook maxAttempts must be greater than zero")
+	}
+	if c.InitialBackoffMs < 0 || c.MaxBackoffMs < c.InitialBackoffMs {
+		return errors.New("webhook backoff values must be positive, and maxBackoffMs must not be lower than initialBackoffMs")
+	}
+	if c.TimeoutSeconds < 1 {
+		return errors.New("webhook timeoutSeconds must be greater than zero")
+	}
+	return nil
+}
+
+// Enabled returns true if callbacks can be signed
+func (c *Config) Enabled() bool {
+	return len(c.Secret) > 0
+}
+
+// ValidateURL checks a callback url is an absolute http(s) url
+// Resolved addresses are checked when delivering; see Dispatcher.ValidateURL
+func ValidateURL(s string) error {
+	if len(s) == 0 || len(s) > MaxURLLength {
+		return ErrInvalidURL
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a callback body
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers job results to callback urls
+type Dispatcher struct {
+	cfg     *Config
+	ctx     context.Context
+	client  *http.Client
+	metrics *monitor.Metrics
+	logger  *log.Logger
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher creates a webhook dispatcher; pending deliveries are abandoned when ctx is done
+func NewDispatcher(ctx context.Context, cfg *Config, m *monitor.Metrics, logger *log.Logger) *Dispatcher {
+	if logger == nil {
+		logger = log.New("zipreport-webhook")
+	}
+	return &Dispatcher{
+		cfg:     cfg,
+		ctx:     ctx,
+		client:  newClient(cfg),
+		metrics: m,
+		logger:  logger,
+	}
+}
+
+// ValidateURL checks a callback url is an absolute http(s) url, and rejects literal forbidden addresses and
+// localhost early, unless allowlisted; names resolving to forbidden addresses are rejected when delivering
+func (d *Dispatcher) ValidateURL(s string) error {
+	if err := ValidateURL(s); err != nil {
+		return err
+	}
+	u, _ := url.Parse(s)
+	host := u.Hostname()
+	if d.cfg.allowedHost(host) {
+		return nil
+	}
+	if ip := net.ParseIP(host); (ip != nil && forbiddenIP(ip)) || strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("%w: %w", ErrInvalidURL, ErrForbiddenAddress)
+	}
+	return nil
+}
+
+// Enabled returns true if callbacks are configured
+func (d *Dispatcher) Enabled() bool {
+	return d.cfg.Enabled()
+}
+
+// Callback returns a job completion callback that delivers the result to callbackURL
+func (d *Dispatcher) Callback(callbackURL string, job *render.Job) func(*jobs.Info, *render.JobResult) {
+	return func(info *jobs.Info, result *render.JobResult) {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(callbackURL, job, info, result)
+		}()
+	}
+}
+
+// Wait blocks until all pending deliveries are finished
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// deliver posts the job result, retrying with exponential backoff on errors and non-2xx responses
+// Successful jobs send the output, failed jobs a JSON error body
+func (d *Dispatcher) deliver(callbackURL string, job *render.Job, info *jobs.Info, result *render.JobResult) {
+	body, contentType := result.Output, job.ContentType()
+	if !result.Success {
+		body, _ = json.Marshal(failureBody(info, result))
+		contentType = "application/json"
+	}
+
+	backoff := time.Duration(d.cfg.InitialBackoffMs) * time.Millisecond
+	maxBackoff := time.Duration(d.cfg.MaxBackoffMs) * time.Millisecond
+	for attempt := 1; attempt <= d.cfg.MaxAttempts; attempt++ {
+		d.metrics.WebhookAttempts.Inc()
+		status, err := d.post(callbackURL, info, contentType, body)
+		if err == nil && status >= 200 && status < 300 {
+			d.logger.Info("callback delivered", log.KV{"id": info.Id, "attempt": attempt, "status": status})
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("callback returned status %d", status)
+		}
+		d.logger.Warn("callback delivery failed", log.KV{"id": info.Id, "attempt": attempt, "error": err.Error()})
+		if attempt == d.cfg.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-d.ctx.Done():
+			d.metrics.WebhookFailures.Inc()
+			d.logger.Error(d.ctx.Err(), "callback delivery abandoned", log.KV{"id": info.Id, "attempt": attempt})
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	d.metrics.WebhookFailures.Inc()
+	d.logger.Error(nil, "callback delivery failed, giving up", log.KV{"id": info.Id, "attempts": d.cfg.MaxAttempts})
+}
+
+func (d *Dispatcher) post(callbackURL string, info *jobs.Info, contentType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HeaderJobId, info.Id)
+	req.Header.Set(HeaderStatus, info.Status)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.cfg.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// drain a bounded amount of the response, so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// failureBody builds the JSON body sent for failed jobs, with the same error code and message as the API
+func failureBody(info *jobs.Info, result *render.JobResult) map[string]any {
+	code := render.ErrorCode(result.Error)
+	return map[string]any{
+		"id":        info.Id,
+		"status":    info.Status,
+		"error":     render.ErrorMessage(result.Error),
+		"code":      code,
+		"retryable": render.Retryable(code),
+	}
+}
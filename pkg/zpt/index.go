@@ -0,0 +1,71 @@
+package zpt
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// IndexFallback lists the index files tried, in order, when no script is requested and fallback is enabled
+var IndexFallback = []string{DefaultScriptName, "index.html"}
+
+// server-wide index fallback setting
+var indexFallback atomic.Bool
+
+// SetIndexFallback enables the discovery of the index file, when no script is requested
+func SetIndexFallback(enabled bool) {
+	indexFallback.Store(enabled)
+}
+
+// IndexError is the error of an index file missing from the archive, with the HTML files it contains
+type IndexError struct {
+	IndexFile string
+	HtmlFiles []string
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("%s: %q", ErrIndexNotFound.Error(), e.IndexFile)
+}
+
+func (e *IndexError) Unwrap() error {
+	return ErrIndexNotFound
+}
+
+// ResolveIndex returns the index file to render; if name is empty, the default script is used or, with fallback
+// enabled, the first existing IndexFallback file or the only top-level HTML file. Fails with an *IndexError if
+// the index file is not in the archive
+func (z *ZptReader) ResolveIndex(name string) (string, error) {
+	if len(name) > 0 {
+		if !z.HasIndex(name) {
+			return name, &IndexError{IndexFile: name, HtmlFiles: z.HtmlFiles()}
+		}
+		return name, nil
+	}
+	if z.HasIndex(DefaultScriptName) {
+		return DefaultScriptName, nil
+	}
+	htmlFiles := z.HtmlFiles()
+	if indexFallback.Load() {
+		for _, fallback := range IndexFallback {
+			if z.HasIndex(fallback) {
+				return fallback, nil
+			}
+		}
+		var topLevel []string
+		for _, f := range htmlFiles {
+			if !strings.Contains(f, "/") && f != DefaultHeaderName && f != DefaultFooterName {
+				topLevel = append(topLevel, f)
+			}
+		}
+		if len(topLevel) == 1 {
+			return topLevel[0], nil
+		}
+	}
+	return DefaultScriptName, &IndexError{IndexFile: DefaultScriptName, HtmlFiles: htmlFiles}
+}
+
+// HasIndex returns true if the index file exists; the query string and fragment are ignored
+func (z *ZptReader) HasIndex(name string) bool {
+	path, ok := localPath("", name)
+	return ok && z.Exists(path)
+}
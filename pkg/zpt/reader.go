@@ -2,8 +2,10 @@ package zpt
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 )
 
@@ -11,6 +13,9 @@ import (
 // against zip-bomb entries that would otherwise exhaust memory.
 const MaxFileSize = 128 << 20 // 128 MiB
 
+var ErrInvalidArchive = errors.New("invalid report archive")
+var ErrIndexNotFound = errors.New("index file not found in the report")
+
 type ZptReader struct {
 	Reader *zip.Reader
 }
@@ -25,8 +30,16 @@ func NewZptReader(r io.ReaderAt, size int64) (*ZptReader, error) {
 
 func (z *ZptReader) Init(src io.ReaderAt, size int64) error {
 	var err error
-	z.Reader, err = zip.NewReader(src, size)
-	return err
+	if z.Reader, err = zip.NewReader(src, size); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	return nil
+}
+
+// Exists returns true if name is a file in the archive
+func (z *ZptReader) Exists(name string) bool {
+	stat, err := fs.Stat(z.Reader, name)
+	return err == nil && !stat.IsDir()
 }
 
 func (z *ZptReader) ReadFile(name string) ([]byte, error) {
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -19,12 +20,19 @@ const ReadTimeout = time.Duration(300) * time.Second
 const WriteTimeout = time.Duration(300) * time.Second
 
 const DefaultScriptName = "report.html"
+const DefaultHeaderName = "_header.html" // optional PDF header template
+const DefaultFooterName = "_footer.html" // optional PDF footer template
+const DataPath = "_zpt/data.json"        // per-request JSON data, if any
 
 type ZptServer struct {
 	Zpt    *ZptReader
 	Server *http.Server
 	Port   int
 	logger *log.Logger
+
+	mx         sync.RWMutex
+	onNotFound func(name string)
+	data       []byte
 }
 
 func NewZptServer(reader *ZptReader, port int, logger *log.Logger) *ZptServer {
@@ -64,6 +72,18 @@ func (z *ZptServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	z.mx.RLock()
+	data := z.data
+	z.mx.RUnlock()
+	if name == DataPath && data != nil {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusOK)
+		if _, err := resp.Write(data); err != nil {
+			z.logger.Error(err, "error writing http response", log.KV{"address": z.Server.Addr})
+		}
+		return
+	}
+
 	buf, err := z.Zpt.ReadFile(name)
 	if err == nil {
 		contentType := mime.TypeByExtension(filepath.Ext(name))
@@ -80,9 +100,29 @@ func (z *ZptServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	} else {
 		z.logger.Warn("error serving file", log.KV{"uri": name})
 	}
+	z.mx.RLock()
+	notFound := z.onNotFound
+	z.mx.RUnlock()
+	if notFound != nil {
+		notFound(name)
+	}
 	resp.WriteHeader(http.StatusNotFound)
 }
 
+// SetData sets the per-request JSON data, served at /_zpt/data.json
+func (z *ZptServer) SetData(data []byte) {
+	z.mx.Lock()
+	defer z.mx.Unlock()
+	z.data = data
+}
+
+// OnNotFound sets a callback, called with the file name whenever a requested file doesn't exist in the ZPT
+func (z *ZptServer) OnNotFound(fn func(name string)) {
+	z.mx.Lock()
+	defer z.mx.Unlock()
+	z.onNotFound = fn
+}
+
 func (z *ZptServer) Run() error {
 	z.logger.Info(fmt.Sprintf("Starting server and listening on %s", z.Server.Addr))
 
@@ -0,0 +1,147 @@
+package zpt
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ValidationReport is the result of a pre-flight check of a report archive
+type ValidationReport struct {
+	Valid         bool       `json:"valid"` // the index file exists, and no referenced assets are missing
+	IndexFile     string     `json:"indexFile"`
+	IndexFound    bool       `json:"indexFound"`
+	Entries       int        `json:"entries"` // files, excluding directories
+	Size          uint64     `json:"size"`    // total decompressed size, in bytes
+	HtmlFiles     []string   `json:"htmlFiles"`
+	MissingAssets []AssetRef `json:"missingAssets"`
+	ExternalUrls  []AssetRef `json:"externalUrls"`
+	Warnings      []string   `json:"warnings,omitempty"` // files that could not be scanned
+}
+
+// AssetRef is an asset reference found in an HTML or CSS file
+type AssetRef struct {
+	File string `json:"file"` // referencing file
+	Url  string `json:"url"`
+}
+
+var (
+	// src="...", href='...' or unquoted values
+	attrRefRegex = regexp.MustCompile(`(?i)\b(?:src|href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	// url(...), in stylesheets and style attributes
+	cssRefRegex = regexp.MustCompile(`(?i)\burl\(\s*(?:"([^"]*)"|'([^']*)'|([^)"']*))\s*\)`)
+)
+
+// IsHtml returns true if name is an HTML file
+func IsHtml(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+// HtmlFiles returns the HTML files in the archive, sorted by name
+func (z *ZptReader) HtmlFiles() []string {
+	result := make([]string, 0)
+	for _, f := range z.Reader.File {
+		if !f.FileInfo().IsDir() && IsHtml(f.Name) {
+			result = append(result, f.Name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// Validate checks that indexFile exists, resolved as in ResolveIndex, and scans HTML and CSS files for relative assets missing from the
+// archive and for absolute external urls; the browser is not used, so assets loaded by scripts are not checked
+func (z *ZptReader) Validate(indexFile string) *ValidationReport {
+	indexFile, err := z.ResolveIndex(indexFile)
+	r := &ValidationReport{
+		IndexFile:     indexFile,
+		IndexFound:    err == nil,
+		HtmlFiles:     z.HtmlFiles(),
+		MissingAssets: make([]AssetRef, 0),
+		ExternalUrls:  make([]AssetRef, 0),
+	}
+
+	for _, f := range z.Reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r.Entries++
+		r.Size += f.UncompressedSize64
+
+		var patterns []*regexp.Regexp
+		switch {
+		case IsHtml(f.Name):
+			patterns = []*regexp.Regexp{attrRefRegex, cssRefRegex}
+		case strings.EqualFold(path.Ext(f.Name), ".css"):
+			patterns = []*regexp.Regexp{cssRefRegex}
+		default:
+			continue
+		}
+		buf, err := z.ReadFile(f.Name)
+		if err != nil {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("could not scan %q: %s", f.Name, err.Error()))
+			continue
+		}
+		z.checkRefs(r, f.Name, scanRefs(buf, patterns))
+	}
+	r.Valid = r.IndexFound && len(r.MissingAssets) == 0
+	return r
+}
+
+// checkRefs classifies the asset references of file
+func (z *ZptReader) checkRefs(r *ValidationReport, file string, refs []string) {
+	for _, ref := range refs {
+		u, err := url.Parse(ref)
+		switch {
+		case err != nil:
+			continue
+		case u.Scheme == "http" || u.Scheme == "https" || (len(u.Scheme) == 0 && len(u.Host) > 0):
+			r.ExternalUrls = append(r.ExternalUrls, AssetRef{File: file, Url: ref})
+			continue
+		case len(u.Scheme) > 0:
+			// data:, javascript:, mailto: and other non-fetched schemes
+			continue
+		}
+		if name, ok := localPath(file, ref); ok && name != DataPath && !z.Exists(name) {
+			r.MissingAssets = append(r.MissingAssets, AssetRef{File: file, Url: ref})
+		}
+	}
+}
+
+// localPath resolves a relative reference in file to an archive path; references without a path (e.g. "#top")
+// are skipped, and references above the archive root resolve to the root, as in the browser
+func localPath(file string, ref string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || len(u.Path) == 0 {
+		return "", false
+	}
+	name := u.Path
+	if !strings.HasPrefix(name, "/") {
+		name = path.Join(path.Dir(file), name)
+	}
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if len(name) == 0 {
+		// served as the default script, as in ZptServer
+		name = DefaultScriptName
+	}
+	return name, true
+}
+
+// scanRefs returns the unique asset references in buf, in order of appearance; template placeholders are skipped
+func scanRefs(buf []byte, patterns []*regexp.Regexp) []string {
+	refs := make([]string, 0)
+	for _, re := range patterns {
+		for _, m := range re.FindAllSubmatch(buf, -1) {
+			ref := strings.TrimSpace(string(m[1]) + string(m[2]) + string(m[3]))
+			if len(ref) == 0 || strings.Contains(ref, "{{") || slices.Contains(refs, ref) {
+				continue
+			}
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
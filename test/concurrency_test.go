@@ -43,7 +43,7 @@ func setupConcurrentServer(t *testing.T, concurrency int) (*httpserver.Server, *
 	cfg.Host = "localhost"
 	cfg.Port = 0
 
-	srv, err := apiserver.NewApiServer(cfg, engine, metrics, logger)
+	srv, err := apiserver.NewApiServer(cfg, engine, metrics, nil, logger)
 	require.NoError(t, err)
 	require.NotNil(t, srv)
 
@@ -1,12 +1,25 @@
 package test
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+	"zipreport-server/pkg/webhook"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -77,6 +90,81 @@ func TestE2E_JSEventTimeout(t *testing.T) {
 	_ = ctx
 }
 
+// TestE2E_JSEventStrictTimeout tests that a ready timeout fails the job in strict mode
+func TestE2E_JSEventStrictTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping JS event strict timeout test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	before := testutil.ToFloat64(sharedMetrics.ReadyTimeouts)
+
+	zipPath := filepath.Join("fixtures", "js-event-timeout.zpt")
+	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":          "index.html",
+		"page_size":       "A4",
+		"margins":         "standard",
+		"js_event":        "true",
+		"js_event_strict": "true",
+		"timeout_js":      "2",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	var response map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "ready_timeout", response["code"])
+	assert.Equal(t, before+1, testutil.ToFloat64(sharedMetrics.ReadyTimeouts))
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_JSEventError tests that a zpt-view-error console message aborts the job with 422
+func TestE2E_JSEventError(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping JS event error test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	for _, jsEvent := range []string{"true", "false"} {
+		t.Run("js_event="+jsEvent, func(t *testing.T) {
+			req := createMultipartRequest(t, filepath.Join("fixtures", "js-event-error.zpt"), map[string]string{
+				"script":        "index.html",
+				"page_size":     "A4",
+				"margins":       "standard",
+				"js_event":      jsEvent,
+				"timeout_js":    "20",
+				"settling_time": "10000",
+			})
+			req.Header.Set("X-Auth-Key", testAuthToken)
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			assert.Less(t, time.Since(start), 10*time.Second, "job should be aborted immediately")
+			var response map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "missing_data", response["code"])
+			assert.Equal(t, "customer record not found", response["error"])
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
 // TestE2E_MultiResource tests that CSS and images are loaded from the ZIP
 func TestE2E_MultiResource(t *testing.T) {
 	if testing.Short() {
@@ -335,3 +423,614 @@ func TestE2E_CustomMargins(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	_ = ctx
 }
+
+// TestE2E_HeaderFooter tests rendering with header and footer templates
+func TestE2E_HeaderFooter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping header/footer test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	zipPath := filepath.Join("fixtures", "test.zpt")
+	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":          "test.html",
+		"page_size":       "A4",
+		"margins":         "standard",
+		"header_template": `<div style="font-size:8px">{{request_id}}</div>`,
+		"footer_template": `<div style="font-size:8px"><span class="pageNumber"></span>/<span class="totalPages"></span></div>`,
+		"footer_height":   "0.5",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.True(t, isValidPDF(w.Body.Bytes()), "Response should be a valid PDF")
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_ImageOutput tests screenshot output formats
+func TestE2E_ImageOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping image output test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	testCases := []struct {
+		format      string
+		contentType string
+		fields      map[string]string
+	}{
+		{"png", "image/png", map[string]string{"transparent": "true", "viewport_width": "800"}},
+		{"jpeg", "image/jpeg", map[string]string{"quality": "60", "full_page": "true"}},
+		{"webp", "image/webp", map[string]string{"device_scale": "2", "viewport_width": "400", "viewport_height": "300"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			tc.fields["script"] = "index.html"
+			tc.fields["output_format"] = tc.format
+			req := createMultipartRequest(t, filepath.Join("fixtures", "multi-resource.zpt"), tc.fields)
+			req.Header.Set("X-Auth-Key", testAuthToken)
+
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tc.format, imageFormat(w.Body.Bytes()))
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+	_ = ctx
+}
+
+// TestE2E_PaginatedImages tests per-page image export as a zip archive
+func TestE2E_PaginatedImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping paginated image test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	zipPath := filepath.Join("fixtures", "multi-page.zpt")
+	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":        "index.html",
+		"page_size":     "A5",
+		"margins":       "standard",
+		"output_format": "png",
+		"paginate":      "true",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+
+	body := w.Body.Bytes()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	require.Greater(t, len(archive.File), 1, "multi-page report should produce several page images")
+	for i, f := range archive.File {
+		assert.Equal(t, fmt.Sprintf("page-%03d.png", i+1), f.Name)
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "png", imageFormat(data))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_PaginatedImagesMatchPdf tests page images are rasterized from the PDF, so break avoidance rules produce the
+// same pages as the PDF output
+func TestE2E_PaginatedImagesMatchPdf(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping paginated image test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	// each block takes 60% of an A5 page and can't be split, so each one starts a new page
+	blocks := strings.Repeat(`<div style="height: 11cm; break-inside: avoid; border: 1px solid black">block</div>`, 5)
+	zipPath := filepath.Join(t.TempDir(), "break-avoid.zpt")
+	require.NoError(t, os.WriteFile(zipPath, buildZipFiles(t, map[string]string{
+		"report.html": "<html><body>" + blocks + "</body></html>",
+	}), 0o600))
+
+	post := func(fields map[string]string) []byte {
+		fields["page_size"] = "A5"
+		fields["margins"] = "standard"
+		req := createMultipartRequest(t, zipPath, fields)
+		req.Header.Set("X-Auth-Key", testAuthToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Body.Bytes()
+	}
+
+	pdf := post(map[string]string{})
+	require.True(t, isValidPDF(pdf))
+	pdfPages := len(regexp.MustCompile(`/Type\s*/Page\b`).FindAll(pdf, -1))
+	require.Equal(t, 5, pdfPages)
+
+	body := post(map[string]string{"output_format": "png", "paginate": "true"})
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	assert.Len(t, archive.File, pdfPages)
+	_ = ctx
+}
+
+// TestE2E_SnapshotOutput tests mhtml and self-contained html snapshots
+func TestE2E_SnapshotOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping snapshot output test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	t.Run("mhtml", func(t *testing.T) {
+		req := createMultipartRequest(t, filepath.Join("fixtures", "multi-resource.zpt"), map[string]string{
+			"script":        "index.html",
+			"output_format": "mhtml",
+		})
+		req.Header.Set("X-Auth-Key", testAuthToken)
+
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "multipart/related", w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		assert.Contains(t, body, "multipart/related")
+		assert.Contains(t, body, "Multi Resource Report")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		req := createMultipartRequest(t, filepath.Join("fixtures", "js-event.zpt"), map[string]string{
+			"script":        "index.html",
+			"output_format": "html",
+			"js_event":      "true",
+		})
+		req.Header.Set("X-Auth-Key", testAuthToken)
+
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		assert.Contains(t, body, "JS Event Report")
+		assert.NotContains(t, body, "<script", "scripts should be removed from the snapshot")
+	})
+
+	t.Run("html_inline_resources", func(t *testing.T) {
+		req := createMultipartRequest(t, filepath.Join("fixtures", "multi-resource.zpt"), map[string]string{
+			"script":        "index.html",
+			"output_format": "html",
+		})
+		req.Header.Set("X-Auth-Key", testAuthToken)
+
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, "data:image/png;base64,", "images should be inlined")
+		assert.Contains(t, body, "#cc0000", "stylesheets should be inlined")
+		assert.NotContains(t, body, `href="style.css"`)
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_WaitFor tests rendering with readiness conditions
+func TestE2E_WaitFor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping wait_for test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	tests := []struct {
+		condition string
+		expected  int
+	}{
+		{"console:zpt-view-ready", http.StatusOK},
+		{"selector:h1", http.StatusOK},
+		{"expr:document.readyState === 'complete'", http.StatusOK},
+		{"fonts", http.StatusOK},
+		{"network_idle:200", http.StatusOK},
+		{"network_idle:abc", http.StatusBadRequest},
+		{"unknown:value", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			req := createMultipartRequest(t, filepath.Join("fixtures", "js-event.zpt"), map[string]string{
+				"script":     "index.html",
+				"page_size":  "A4",
+				"margins":    "standard",
+				"timeout_js": "5",
+				"wait_for":   tt.condition,
+			})
+			req.Header.Set("X-Auth-Key", testAuthToken)
+
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expected, w.Code)
+			if tt.expected == http.StatusOK {
+				assert.True(t, isValidPDF(w.Body.Bytes()), "Response should be a valid PDF")
+			}
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_Diagnostics tests console output, page errors and missing files are returned to the caller
+func TestE2E_Diagnostics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping diagnostics test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	req := createMultipartRequest(t, filepath.Join("fixtures", "diagnostics.zpt"), map[string]string{
+		"script":      "index.html",
+		"page_size":   "A4",
+		"margins":     "standard",
+		"diagnostics": "true",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+
+	parts := map[string][]byte{}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(p)
+		require.NoError(t, err)
+		parts[p.FormName()] = data
+	}
+	assert.True(t, isValidPDF(parts["output"]), "output part should be a valid PDF")
+
+	var diag struct {
+		Console []struct {
+			Level   string `json:"level"`
+			Message string `json:"message"`
+		} `json:"console"`
+		Exceptions []struct {
+			Message string `json:"message"`
+		} `json:"exceptions"`
+		NotFound []string `json:"notFound"`
+	}
+	require.NoError(t, json.Unmarshal(parts["diagnostics"], &diag))
+	require.NotEmpty(t, diag.Console)
+	assert.Equal(t, "log", diag.Console[0].Level)
+	assert.Equal(t, "diagnostics-test 42", diag.Console[0].Message)
+	require.NotEmpty(t, diag.Exceptions)
+	assert.Contains(t, diag.Exceptions[0].Message, "diagnostics-exception")
+	assert.Contains(t, diag.NotFound, "missing.css")
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_Data tests per-request JSON data is exposed to the page
+func TestE2E_Data(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping data test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	fields := map[string]string{
+		"script":          "index.html",
+		"page_size":       "A4",
+		"margins":         "standard",
+		"js_event":        "true",
+		"js_event_strict": "true",
+		"timeout_js":      "10",
+	}
+	data := `{"customer": "ACME Corporation"}`
+
+	t.Run("field", func(t *testing.T) {
+		fields["data"] = data
+		defer delete(fields, "data")
+		req := createMultipartRequest(t, filepath.Join("fixtures", "data.zpt"), fields)
+		req.Header.Set("X-Auth-Key", testAuthToken)
+
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, isValidPDF(w.Body.Bytes()))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		report, err := writer.CreateFormFile("report", "data.zpt")
+		require.NoError(t, err)
+		zpt, err := os.ReadFile(filepath.Join("fixtures", "data.zpt"))
+		require.NoError(t, err)
+		_, err = report.Write(zpt)
+		require.NoError(t, err)
+		part, err := writer.CreateFormFile("data.json", "data.json")
+		require.NoError(t, err)
+		_, err = part.Write([]byte(data))
+		require.NoError(t, err)
+		for key, val := range fields {
+			require.NoError(t, writer.WriteField(key, val))
+		}
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest("POST", "/v2/render", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Auth-Key", testAuthToken)
+
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, isValidPDF(w.Body.Bytes()))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		fields["data"] = `{"customer": `
+		defer delete(fields, "data")
+		req := createMultipartRequest(t, filepath.Join("fixtures", "data.zpt"), fields)
+		req.Header.Set("X-Auth-Key", testAuthToken)
+
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_Templates tests stored template upload, render by id and deletion
+func TestE2E_Templates(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping template registry test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	// upload
+	req := createMultipartRequest(t, filepath.Join("fixtures", "data.zpt"), map[string]string{"name": "data"})
+	req.URL.Path = "/v2/templates"
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var tpl struct {
+		Id       string `json:"id"`
+		Name     string `json:"name"`
+		Versions []struct {
+			Version int `json:"version"`
+		} `json:"versions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tpl))
+	assert.Equal(t, "data", tpl.Name)
+	require.Len(t, tpl.Versions, 1)
+
+	// render with a JSON body
+	body := `{"script": "index.html", "page_size": "A4", "margins": "standard", "js_event": true,
+		"js_event_strict": true, "timeout_js": 10, "data": {"customer": "ACME Corporation"}}`
+	req = httptest.NewRequest("POST", "/v2/templates/"+tpl.Id+"/render", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, isValidPDF(w.Body.Bytes()))
+
+	// list
+	req = httptest.NewRequest("GET", "/v2/templates", nil)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), tpl.Id)
+
+	// delete
+	req = httptest.NewRequest("DELETE", "/v2/templates/"+tpl.Id, nil)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	req = httptest.NewRequest("POST", "/v2/templates/"+tpl.Id+"/render", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestE2E_AsyncJobs tests job submission, status polling and result retrieval
+func TestE2E_AsyncJobs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping async job test in short mode")
+	}
+
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	req := createMultipartRequest(t, filepath.Join("fixtures", "js-event.zpt"), map[string]string{
+		"script":    "index.html",
+		"page_size": "A4",
+		"margins":   "standard",
+		"js_event":  "true",
+	})
+	req.URL.Path = "/v2/jobs"
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var info struct {
+		Id          string  `json:"id"`
+		Status      string  `json:"status"`
+		ElapsedTime float64 `json:"elapsedTime"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, "queued", info.Status)
+	assert.Equal(t, "/v2/jobs/"+info.Id, w.Header().Get("Location"))
+
+	// poll until finished
+	deadline := time.Now().Add(60 * time.Second)
+	for info.Status == "queued" || info.Status == "running" {
+		require.True(t, time.Now().Before(deadline), "job did not finish in time")
+		time.Sleep(200 * time.Millisecond)
+		req = httptest.NewRequest("GET", "/v2/jobs/"+info.Id, nil)
+		req.Header.Set("X-Auth-Key", testAuthToken)
+		w = httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	}
+	require.Equal(t, "done", info.Status)
+	assert.Greater(t, info.ElapsedTime, 0.0)
+
+	req = httptest.NewRequest("GET", "/v2/jobs/"+info.Id+"/result", nil)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.True(t, isValidPDF(w.Body.Bytes()))
+
+	// unknown jobs
+	req = httptest.NewRequest("GET", "/v2/jobs/unknown", nil)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	_ = ctx
+}
+
+// TestE2E_RenderCallback tests /v2/render with async=true and a result callback
+func TestE2E_RenderCallback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping render callback test in short mode")
+	}
+
+	srv, engine, _, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan delivery, 4)
+	var calls atomic.Int32
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// fail the first attempt, to exercise retries
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		deliveries <- delivery{r.Header.Clone(), body}
+	}))
+	defer callback.Close()
+
+	fields := map[string]string{
+		"script":       "index.html",
+		"page_size":    "A4",
+		"margins":      "standard",
+		"async":        "true",
+		"callback_url": callback.URL + "/done",
+	}
+	req := createMultipartRequest(t, filepath.Join("fixtures", "js-event.zpt"), fields)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var info struct {
+		Id string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+
+	select {
+	case d := <-deliveries:
+		assert.Equal(t, info.Id, d.header.Get(webhook.HeaderJobId))
+		assert.Equal(t, "done", d.header.Get(webhook.HeaderStatus))
+		assert.Equal(t, "application/pdf", d.header.Get("Content-Type"))
+		assert.Equal(t, webhook.Sign(testWebhookSecret, d.header.Get(webhook.HeaderTimestamp), d.body), d.header.Get(webhook.HeaderSignature))
+		assert.True(t, isValidPDF(d.body))
+	case <-time.After(60 * time.Second):
+		t.Fatal("callback not delivered in time")
+	}
+
+	// invalid callback urls and callbacks without async are rejected
+	fields["callback_url"] = "ftp://example.com/done"
+	req = createMultipartRequest(t, filepath.Join("fixtures", "js-event.zpt"), fields)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	fields["callback_url"] = callback.URL
+	fields["async"] = "false"
+	req = createMultipartRequest(t, filepath.Join("fixtures", "js-event.zpt"), fields)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,12 +2,17 @@ package test
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
 	"testing"
 	"time"
+	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/zpt"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,10 +36,11 @@ func TestError_CorruptZip(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code,
 		"Corrupt ZIP should return 400 Bad Request")
+	assert.Contains(t, w.Body.String(), render.CodeInvalidArchive)
 	_ = ctx
 }
 
-// TestError_MissingIndexFile tests that a ZIP missing the default index returns 500
+// TestError_MissingIndexFile tests that a ZIP missing the default index returns 400, with the index_not_found code
 func TestError_MissingIndexFile(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping missing index test in short mode")
@@ -54,9 +60,15 @@ func TestError_MissingIndexFile(t *testing.T) {
 	w := httptest.NewRecorder()
 	srv.Router.ServeHTTP(w, req)
 
-	// Navigation to non-existent report.html should cause a server error
-	assert.Equal(t, http.StatusInternalServerError, w.Code,
-		"Missing index file should return 500")
+	// The missing report.html is detected before rendering, and the HTML files in the report are listed
+	assert.Equal(t, http.StatusBadRequest, w.Code,
+		"Missing index file should return 400")
+	var response map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, render.CodeIndexNotFound, response["code"])
+	assert.Equal(t, false, response["retryable"])
+	assert.NotEmpty(t, response["requestId"])
+	assert.Equal(t, []any{"other.html"}, response["htmlFiles"])
 
 	time.Sleep(100 * time.Millisecond)
 	_ = ctx
@@ -147,3 +159,39 @@ func TestError_NoFileField(t *testing.T) {
 		"Missing report field should return 400 Bad Request")
 	_ = ctx
 }
+
+// TestErrorCodes tests the classification of render job errors
+func TestErrorCodes(t *testing.T) {
+	_, err := zpt.NewZptReader(bytes.NewReader([]byte("not a zip file")), 14)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, zpt.ErrInvalidArchive)
+
+	cases := []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{err, render.CodeInvalidArchive, false},
+		{fmt.Errorf("%w: %w", zpt.ErrIndexNotFound, errors.New("navigation failed")), render.CodeIndexNotFound, false},
+		{fmt.Errorf("%w: %w", render.ErrJobTimeout, render.ErrNavigationFailed), render.CodeJobTimeout, true},
+		{render.ErrReadyTimeout, render.CodeReadyTimeout, false},
+		{fmt.Errorf("%w: %w", render.ErrBrowserUnavailable, errors.New("connection refused")), render.CodeBrowserUnavailable, true},
+		{fmt.Errorf("%w: %w", render.ErrPdfFailed, errors.New("print failed")), render.CodePdfFailed, true},
+		{fmt.Errorf("%w: %d pages", render.ErrTooManyPages, 620), render.CodeTooManyPages, false},
+		{render.ErrShuttingDown, render.CodeShuttingDown, true},
+		{&render.TemplateError{Code: "no_data", Message: "missing data"}, "no_data", false},
+		{errors.New("failed to build server"), render.CodeInternal, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.code, render.ErrorCode(c.err), c.err.Error())
+		assert.Equal(t, c.retryable, render.Retryable(render.ErrorCode(c.err)), c.err.Error())
+	}
+
+	// details of unknown errors are not exposed
+	assert.Equal(t, render.MessageInternal, render.ErrorMessage(errors.New("/tmp/zipreport: permission denied")))
+	assert.Equal(t, "missing data", render.ErrorMessage(&render.TemplateError{Code: "no_data", Message: "missing data"}))
+
+	// stored results are rebuilt from their code
+	assert.ErrorIs(t, render.ErrorFromCode(render.CodeJobTimeout, ""), render.ErrJobTimeout)
+	assert.Equal(t, "failed", render.ErrorFromCode("unknown", "failed").Error())
+}
@@ -19,6 +19,18 @@ echo "  Created js-event.zpt"
 (cd js-event-timeout && zip -r ../js-event-timeout.zpt .)
 echo "  Created js-event-timeout.zpt"
 
+# js-event-error.zpt
+(cd js-event-error && zip -r ../js-event-error.zpt .)
+echo "  Created js-event-error.zpt"
+
+# diagnostics.zpt
+(cd diagnostics && zip -r ../diagnostics.zpt .)
+echo "  Created diagnostics.zpt"
+
+# data.zpt
+(cd data && zip -r ../data.zpt .)
+echo "  Created data.zpt"
+
 # multi-resource.zpt
 (cd multi-resource && zip -r ../multi-resource.zpt .)
 echo "  Created multi-resource.zpt"
@@ -33,3 +33,17 @@ func isValidPDF(data []byte) bool {
 	trimmed := bytes.TrimRight(data, "\r\n\x00 ")
 	return bytes.HasSuffix(trimmed, []byte("%%EOF"))
 }
+
+// imageFormat detects png, jpeg and webp images from their magic bytes.
+// Returns an empty string for unknown formats.
+func imageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case len(data) > 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -12,16 +13,22 @@ import (
 	"testing"
 	"time"
 	"zipreport-server/internal/apiserver"
+	"zipreport-server/pkg/browser"
+	"zipreport-server/pkg/jobs"
 	"zipreport-server/pkg/monitor"
+	"zipreport-server/pkg/registry"
 	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/webhook"
 
 	"github.com/oddbit-project/blueprint/log"
 	"github.com/oddbit-project/blueprint/provider/httpserver"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 const testAuthToken = "test-secret-token"
+const testWebhookSecret = "test-webhook-secret"
 
 var (
 	// Shared metrics instance to avoid duplicate registration
@@ -59,7 +66,27 @@ func setupTestServer(t *testing.T) (*httpserver.Server, *render.Engine, context.
 	cfg.Host = "localhost"
 	cfg.Port = 0 // Let OS assign port
 
-	srv, err := apiserver.NewApiServer(cfg, engine, metrics, logger)
+	// stored templates, in a per-test directory
+	reg, err := registry.NewRegistry(t.TempDir(), logger)
+	require.NoError(t, err)
+	t.Cleanup(reg.Close)
+
+	// result callbacks, with short retry intervals
+	webhookCfg := webhook.NewConfig()
+	webhookCfg.Secret = testWebhookSecret
+	webhookCfg.InitialBackoffMs = 10
+	webhookCfg.MaxBackoffMs = 100
+	webhookCfg.AllowedHosts = []string{"127.0.0.1"} // httptest callback servers
+
+	webhooks := webhook.NewDispatcher(ctx, webhookCfg, metrics, logger)
+	mgr := jobs.NewManager(ctx, engine, metrics, time.Minute, nil, logger)
+	mgr.SetCallbackHandler(webhooks.Callback)
+
+	srv, err := apiserver.NewApiServer(cfg, engine, metrics, &apiserver.Services{
+		Registry: reg,
+		Jobs:     mgr,
+		Webhooks: webhooks,
+	}, logger)
 	require.NoError(t, err)
 	require.NotNil(t, srv)
 
@@ -195,6 +222,214 @@ func TestRenderEndpoint_MissingFile(t *testing.T) {
 	_ = ctx
 }
 
+// TestRenderEndpoint_QueueFull tests requests are rejected with 429 when the render queue is full
+func TestRenderEndpoint_QueueFull(t *testing.T) {
+	srv, engine, _, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	// fill the queue
+	engine.SetQueueLimits(1, time.Minute)
+	ticket, err := engine.Queue.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	defer ticket.Release()
+
+	req := createMultipartRequest(t, filepath.Join("fixtures", "test.zpt"), map[string]string{
+		"page_size": "A4",
+		"margins":   "standard",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	body := &countingReader{r: req.Body}
+	req.Body = io.NopCloser(body)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "queue_full")
+	assert.Zero(t, body.n, "the upload must not be read before the job is queued")
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// TestRenderEndpoint_ClientDisconnect tests that a job waiting for a render slot is canceled with the request
+func TestRenderEndpoint_ClientDisconnect(t *testing.T) {
+	srv, engine, _, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	// hold the only render slot
+	ticket, err := engine.Queue.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, ticket.Wait(context.Background()))
+	defer ticket.Release()
+
+	canceled := testutil.ToFloat64(sharedMetrics.CanceledOps)
+	failed := testutil.ToFloat64(sharedMetrics.FailedOps)
+
+	reqCtx, reqCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer reqCancel()
+	req := createMultipartRequest(t, filepath.Join("fixtures", "test.zpt"), map[string]string{
+		"script":    "test.html",
+		"page_size": "A4",
+		"margins":   "standard",
+	}).WithContext(reqCtx)
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, apiserver.StatusClientClosedRequest, w.Code)
+	assert.Contains(t, w.Body.String(), render.CodeCanceled)
+	assert.Equal(t, canceled+1, testutil.ToFloat64(sharedMetrics.CanceledOps))
+	assert.Equal(t, failed, testutil.ToFloat64(sharedMetrics.FailedOps))
+	assert.Equal(t, 0, engine.Queue.Depth())
+}
+
+// TestRenderEndpoint_Drain tests that jobs still waiting when the drain grace period expires are canceled, and
+// that new jobs are rejected while draining
+func TestRenderEndpoint_Drain(t *testing.T) {
+	srv, engine, _, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	// hold the only render slot
+	ticket, err := engine.Queue.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, ticket.Wait(context.Background()))
+	defer ticket.Release()
+
+	post := func() *httptest.ResponseRecorder {
+		req := createMultipartRequest(t, filepath.Join("fixtures", "test.zpt"), map[string]string{
+			"script":    "test.html",
+			"page_size": "A4",
+			"margins":   "standard",
+		})
+		req.Header.Set("X-Auth-Key", testAuthToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// job waiting for a slot
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- post()
+	}()
+	require.Eventually(t, func() bool { return engine.Queue.Depth() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	engine.Drain(100 * time.Millisecond)
+	assert.True(t, engine.Draining())
+	w := <-done
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), render.CodeShuttingDown)
+
+	// new jobs are rejected
+	w = post()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), render.CodeShuttingDown)
+}
+
+// TestHealthEndpoints tests the unauthenticated liveness and readiness probes
+func TestHealthEndpoints(t *testing.T) {
+	srv, engine, _, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	probe := func(path string) (*httptest.ResponseRecorder, *render.Readiness) {
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		ready := &render.Readiness{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), ready))
+		return w, ready
+	}
+
+	w, _ := probe("/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// without connected browsers, a browser is started and probed, and kept in the pool
+	browsers := testutil.ToFloat64(sharedMetrics.Browsers)
+	w, ready := probe("/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, ready.Ready)
+	assert.True(t, ready.Browser.Ok)
+	assert.NotEmpty(t, ready.Browser.Version)
+	assert.Equal(t, 1, ready.Slots.Size)
+	assert.Equal(t, 1, ready.Slots.Free)
+	assert.True(t, ready.Slots.Ok)
+	assert.Equal(t, browsers+1, testutil.ToFloat64(sharedMetrics.Browsers))
+
+	// no free render slot; the cached browser check is reused
+	ticket, err := engine.Queue.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, ticket.Wait(context.Background()))
+	checkedAt := ready.Browser.CheckedAt
+	w, ready = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.False(t, ready.Slots.Ok)
+	assert.Equal(t, 0, ready.Slots.Free)
+	assert.Equal(t, checkedAt, ready.Browser.CheckedAt)
+
+	// busy slots can be reported without failing readiness
+	engine.SetReadyRequireFreeSlot(false)
+	w, ready = probe("/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, ready.Ready)
+	assert.False(t, ready.Slots.Ok)
+	engine.SetReadyRequireFreeSlot(true)
+	ticket.Release()
+
+	// not ready while shutting down
+	engine.Drain(0)
+	w, ready = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.True(t, ready.Draining)
+	assert.False(t, ready.Ready)
+
+	w, _ = probe("/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestInfoEndpoint tests the capabilities and version discovery endpoint
+func TestInfoEndpoint(t *testing.T) {
+	srv, engine, _, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	req := httptest.NewRequest("GET", "/v2/info", nil)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req.Header.Set("X-Auth-Key", testAuthToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	info := &apiserver.ServerInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), info))
+	assert.Equal(t, browser.Revision, info.Browser.Revision)
+	assert.NotEmpty(t, info.Browser.Version)
+	assert.Contains(t, info.Options.PageSizes, render.PageA4)
+	assert.Contains(t, info.Options.PageSizes, render.PageCustom)
+	assert.Equal(t, render.ValidOutputFormats, info.Options.OutputFormats)
+	assert.Equal(t, int64(apiserver.MaxUploadBytes), info.Limits.MaxUploadBytes)
+	assert.Equal(t, render.JobMaxTimeout, info.Limits.MaxTimeoutJob)
+	assert.Equal(t, 1, info.Concurrency)
+	assert.True(t, info.Features.Templates)
+	assert.True(t, info.Features.Jobs)
+	assert.True(t, info.Features.Callbacks)
+}
+
 // TestRenderEndpoint_InvalidPageSize tests invalid page size validation
 func TestRenderEndpoint_InvalidPageSize(t *testing.T) {
 	srv, engine, ctx, cancel := setupTestServer(t)
@@ -404,3 +639,148 @@ func TestRenderEndpoint_BooleanParsing(t *testing.T) {
 	}
 	_ = ctx
 }
+
+// TestRenderEndpoint_InvalidHeaderHeight tests header/footer height validation
+func TestRenderEndpoint_InvalidHeaderHeight(t *testing.T) {
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	zipPath := filepath.Join("fixtures", "test.zpt")
+	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":          "test.html",
+		"page_size":       "A4",
+		"margins":         "standard",
+		"header_template": "<div>header</div>",
+		"header_height":   "-1",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_ = ctx
+}
+
+// TestRenderEndpoint_PreferCSSPageSize tests page_size validation is skipped when CSS page size is preferred
+func TestRenderEndpoint_PreferCSSPageSize(t *testing.T) {
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	zipPath := filepath.Join("fixtures", "test.zpt")
+	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":               "test.html",
+		"margins":              "standard",
+		"prefer_css_page_size": "true",
+		"print_background":     "true",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	time.Sleep(100 * time.Millisecond)
+	_ = ctx
+}
+
+// TestRenderEndpoint_CustomPageSize tests custom page sizes and margin units
+func TestRenderEndpoint_CustomPageSize(t *testing.T) {
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	testCases := []struct {
+		name     string
+		fields   map[string]string
+		expected int
+	}{
+		{"label_mm", map[string]string{"page_size": "custom", "page_width": "62mm", "page_height": "29mm", "margins": "custom", "margin_left": "2mm", "margin_right": "2mm"}, http.StatusOK},
+		{"receipt_cm", map[string]string{"page_size": "custom", "page_width": "8cm", "page_height": "20cm", "margins": "minimal"}, http.StatusOK},
+		{"margin_pt", map[string]string{"page_size": "A4", "margins": "custom", "margin_top": "36pt", "margin_bottom": "48px"}, http.StatusOK},
+		{"missing_width", map[string]string{"page_size": "custom", "page_height": "20cm", "margins": "none"}, http.StatusBadRequest},
+		{"invalid_unit", map[string]string{"page_size": "custom", "page_width": "8furlongs", "page_height": "20cm", "margins": "none"}, http.StatusBadRequest},
+		{"too_large", map[string]string{"page_size": "custom", "page_width": "10000in", "page_height": "20cm", "margins": "none"}, http.StatusBadRequest},
+		{"margins_exceed_page", map[string]string{"page_size": "A4", "margins": "custom", "margin_left": "15cm", "margin_right": "15cm"}, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["script"] = "test.html"
+			req := createMultipartRequest(t, filepath.Join("fixtures", "test.zpt"), tc.fields)
+			req.Header.Set("X-Auth-Key", testAuthToken)
+
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+	_ = ctx
+}
+
+// TestRenderEndpoint_Preset tests rendering with a server-side preset and per-request overrides
+func TestRenderEndpoint_Preset(t *testing.T) {
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	landscape := true
+	require.NoError(t, render.RegisterPreset("test-report", &render.Preset{
+		PageSize:  render.PageA5,
+		Margins:   render.MarginMinimal,
+		Landscape: &landscape,
+	}))
+
+	testCases := []struct {
+		name     string
+		fields   map[string]string
+		expected int
+	}{
+		{"preset_only", map[string]string{"preset": "test-report"}, http.StatusOK},
+		{"preset_override", map[string]string{"preset": "test-report", "page_size": "A4", "landscape": "false"}, http.StatusOK},
+		{"preset_invalid_override", map[string]string{"preset": "test-report", "margins": "invalid"}, http.StatusBadRequest},
+		{"unknown_preset", map[string]string{"preset": "does-not-exist", "page_size": "A4", "margins": "standard"}, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fields["script"] = "test.html"
+			req := createMultipartRequest(t, filepath.Join("fixtures", "test.zpt"), tc.fields)
+			req.Header.Set("X-Auth-Key", testAuthToken)
+
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+	_ = ctx
+}
+
+// TestRenderEndpoint_InvalidOutputFormat tests output format validation
+func TestRenderEndpoint_InvalidOutputFormat(t *testing.T) {
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	zipPath := filepath.Join("fixtures", "test.zpt")
+	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":        "test.html",
+		"page_size":     "A4",
+		"margins":       "standard",
+		"output_format": "gif",
+	})
+	req.Header.Set("X-Auth-Key", testAuthToken)
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_ = ctx
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zipreport-server/pkg/jobs"
+	"zipreport-server/pkg/render"
+
+	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestJobStore_Files tests job persistence, and loading finished jobs in the job manager
+func TestJobStore_Files(t *testing.T) {
+	logConfig := log.NewDefaultConfig()
+	logConfig.Level = "error"
+	require.NoError(t, log.Configure(logConfig))
+	logger := log.New("test-jobstore")
+
+	dir := t.TempDir()
+	store, err := jobs.NewFileStore(dir, logger)
+	require.NoError(t, err)
+
+	zpt, err := os.ReadFile(filepath.Join("fixtures", "test.zpt"))
+	require.NoError(t, err)
+
+	// queued job, with its payload and options
+	job := render.NewRenderJob(nil, uuid.New())
+	job.PageSize = render.PageLetter
+	job.Data = []byte(`{"name":"test"}`)
+	job.WaitFor = []render.WaitCondition{{Type: render.WaitFonts}}
+	queued := &jobs.Record{
+		Info:        jobs.Info{Id: job.Id.String(), Status: jobs.StatusQueued, CreatedAt: time.Now().UTC()},
+		Job:         job,
+		CallbackURL: "https://example.com/done",
+	}
+	require.NoError(t, store.Create(queued, bytes.NewReader(zpt)))
+
+	records, err := store.Load()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, queued.Info.Id, records[0].Info.Id)
+	assert.Equal(t, render.PageLetter, records[0].Job.PageSize)
+	assert.Equal(t, job.Data, records[0].Job.Data)
+	assert.Equal(t, job.WaitFor, records[0].Job.WaitFor)
+	assert.Equal(t, queued.CallbackURL, records[0].CallbackURL)
+
+	payload, err := store.Payload(queued.Info.Id)
+	require.NoError(t, err)
+	buf, err := io.ReadAll(payload)
+	_ = payload.Close()
+	require.NoError(t, err)
+	assert.Equal(t, zpt, buf)
+
+	// finished jobs keep the output, and drop the payload
+	now := time.Now().UTC()
+	expires := now.Add(time.Hour)
+	done := &jobs.Record{
+		Info: jobs.Info{Id: queued.Info.Id, Status: jobs.StatusDone, CreatedAt: queued.Info.CreatedAt,
+			FinishedAt: &now, ExpiresAt: &expires, ElapsedTime: 1.5, ContentType: "application/pdf", Size: 8},
+		Job: job,
+	}
+	require.NoError(t, store.Finish(done, []byte("%PDF-1.4")))
+	_, err = store.Payload(done.Info.Id)
+	assert.Error(t, err)
+
+	// failed jobs keep the template error
+	failedJob := render.NewRenderJob(nil, uuid.New())
+	failed := &jobs.Record{
+		Info:          jobs.Info{Id: failedJob.Id.String(), Status: jobs.StatusQueued, CreatedAt: now},
+		Job:           failedJob,
+		TemplateError: &render.TemplateError{Code: "no_data", Message: "missing data"},
+	}
+	require.NoError(t, store.Create(failed, bytes.NewReader(zpt)))
+	failed.Info.Status, failed.Info.FinishedAt, failed.Info.ExpiresAt = jobs.StatusFailed, &now, &expires
+	require.NoError(t, store.Finish(failed, nil))
+
+	// incomplete and invalid entries are skipped
+	require.NoError(t, os.Mkdir(filepath.Join(dir, uuid.NewString()), 0o700))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "not-a-job"), 0o700))
+
+	// finished jobs are available after a restart
+	mgr := jobs.NewManager(context.Background(), nil, sharedMetrics, time.Hour, store, logger)
+	require.NoError(t, mgr.Resume())
+	assert.Len(t, mgr.List(), 2)
+
+	info, _, result, err := mgr.Result(done.Info.Id, render.DefaultClient)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusDone, info.Status)
+	require.NotNil(t, result)
+	assert.True(t, result.Success)
+	assert.Equal(t, []byte("%PDF-1.4"), result.Output)
+
+	// the output is read from the store on each request, not kept in memory
+	_, _, result, err = mgr.Result(done.Info.Id, render.DefaultClient)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("%PDF-1.4"), result.Output)
+
+	_, _, result, err = mgr.Result(failed.Info.Id, render.DefaultClient)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	assert.False(t, result.Success)
+	var tplErr *render.TemplateError
+	require.ErrorAs(t, result.Error, &tplErr)
+	assert.Equal(t, "no_data", tplErr.Code)
+
+	// jobs are only visible to their client; stored jobs without a client belong to the default client
+	_, err = mgr.Get(done.Info.Id, "other-client")
+	assert.ErrorIs(t, err, jobs.ErrNotFound)
+	_, _, _, err = mgr.Result(done.Info.Id, "other-client")
+	assert.ErrorIs(t, err, jobs.ErrNotFound)
+	_, err = mgr.Get(done.Info.Id, render.DefaultClient)
+	assert.NoError(t, err)
+
+	// deleted jobs are removed from disk
+	require.NoError(t, store.Delete(done.Info.Id))
+	_, err = os.Stat(filepath.Join(dir, done.Info.Id))
+	assert.True(t, os.IsNotExist(err))
+	assert.ErrorIs(t, store.Delete("../escape"), jobs.ErrNotFound)
+}
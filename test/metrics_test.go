@@ -70,9 +70,10 @@ func TestMetrics_FailureCounter(t *testing.T) {
 
 	before := testutil.ToFloat64(sharedMetrics.FailedOps)
 
-	// Use missing-index.zpt to trigger a render failure (navigation to non-existent file)
-	zipPath := filepath.Join("fixtures", "missing-index.zpt")
+	// Use js-event-error.zpt to trigger a render failure (the template aborts the job)
+	zipPath := filepath.Join("fixtures", "js-event-error.zpt")
 	req := createMultipartRequest(t, zipPath, map[string]string{
+		"script":    "index.html",
 		"page_size": "A4",
 		"margins":   "standard",
 	})
@@ -81,7 +82,7 @@ func TestMetrics_FailureCounter(t *testing.T) {
 	w := httptest.NewRecorder()
 	srv.Router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 
 	after := testutil.ToFloat64(sharedMetrics.FailedOps)
 	assert.Greater(t, after, before, "FailedOps should be incremented after failed render")
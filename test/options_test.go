@@ -0,0 +1,357 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"zipreport-server/pkg/render"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestJobOptions_HeaderFooter tests header/footer templates are expanded and reserve margin space
+func TestJobOptions_HeaderFooter(t *testing.T) {
+	id := uuid.New()
+	job := render.NewRenderJob(nil, id)
+	job.MarginStyle = render.MarginStandard
+
+	// no templates, no header/footer
+	opts := job.ToPDFOptions()
+	assert.False(t, opts.DisplayHeaderFooter)
+	assert.Equal(t, "", opts.HeaderTemplate)
+	assert.InDelta(t, 0.4, *opts.MarginTop, 0.0001)
+	assert.InDelta(t, 0.4, *opts.MarginBottom, 0.0001)
+
+	// header only; footer must be blanked so Chrome doesn't print its default
+	job.HeaderTemplate = `<div>{{request_id}} <span class="pageNumber"></span>/<span class="totalPages"></span></div>`
+	job.HeaderHeight = 0.5
+	opts = job.ToPDFOptions()
+	assert.True(t, opts.DisplayHeaderFooter)
+	assert.Contains(t, opts.HeaderTemplate, id.String())
+	assert.Contains(t, opts.HeaderTemplate, `<span class="pageNumber"></span>`)
+	assert.Equal(t, "<span></span>", opts.FooterTemplate)
+	assert.InDelta(t, 0.9, *opts.MarginTop, 0.0001)
+	assert.InDelta(t, 0.4, *opts.MarginBottom, 0.0001)
+
+	// footer with custom margins
+	job.FooterTemplate = `<div>{{page_size}}</div>`
+	job.FooterHeight = 0.3
+	job.MarginStyle = render.MarginCustom
+	job.MarginBottom = 1
+	opts = job.ToPDFOptions()
+	assert.Equal(t, "<div>A4</div>", opts.FooterTemplate)
+	assert.InDelta(t, 1.3, *opts.MarginBottom, 0.0001)
+}
+
+// TestJobOptions_BackgroundAndCSSPageSize tests print background and CSS page size flags are passed to Chrome
+func TestJobOptions_BackgroundAndCSSPageSize(t *testing.T) {
+	job := render.NewRenderJob(nil, uuid.New())
+	opts := job.ToPDFOptions()
+	assert.False(t, opts.PrintBackground)
+	assert.False(t, opts.PreferCSSPageSize)
+
+	job.PrintBackground = true
+	job.PreferCSSPageSize = true
+	opts = job.ToPDFOptions()
+	assert.True(t, opts.PrintBackground)
+	assert.True(t, opts.PreferCSSPageSize)
+	// fallback paper size is still set, for documents without @page size
+	assert.NotNil(t, opts.PaperWidth)
+	assert.NotNil(t, opts.PaperHeight)
+}
+
+// TestJobOptions_ParseLength tests unit suffixes are normalized to inches
+func TestJobOptions_ParseLength(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected float64
+		valid    bool
+	}{
+		{"1", 1, true},
+		{"0.5in", 0.5, true},
+		{"25.4mm", 1, true},
+		{"2.54cm", 1, true},
+		{"96px", 1, true},
+		{"72pt", 1, true},
+		{" 10 MM ", 10 / 25.4, true},
+		{"", 0, false},
+		{"mm", 0, false},
+		{"10km", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			v, err := render.ParseLength(tc.value)
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tc.expected, v, 0.0001)
+		})
+	}
+}
+
+// TestJobOptions_Validate tests paper size and margin bounds
+func TestJobOptions_Validate(t *testing.T) {
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.NoError(t, job.Validate())
+
+	// custom paper size is passed to Chrome
+	job.PageSize = render.PageCustom
+	job.PageWidth, job.PageHeight = 4, 6
+	assert.NoError(t, job.Validate())
+	opts := job.ToPDFOptions()
+	assert.Equal(t, 4.0, *opts.PaperWidth)
+	assert.Equal(t, 6.0, *opts.PaperHeight)
+
+	// absurd sizes
+	job.PageWidth = 0
+	assert.ErrorIs(t, job.Validate(), render.ErrInvalidPaperSize)
+	job.PageWidth = render.MaxPaperSize + 1
+	assert.ErrorIs(t, job.Validate(), render.ErrInvalidPaperSize)
+
+	// margins larger than the paper
+	job.PageWidth = 4
+	job.MarginStyle = render.MarginCustom
+	job.MarginLeft, job.MarginRight = 2, 2
+	assert.ErrorIs(t, job.Validate(), render.ErrInvalidMargins)
+	job.MarginLeft, job.MarginRight = 1, 1
+	assert.NoError(t, job.Validate())
+
+	// in landscape, width and height are swapped
+	job.MarginLeft, job.MarginRight = 2.5, 2.5
+	assert.ErrorIs(t, job.Validate(), render.ErrInvalidMargins)
+	job.Landscape = true
+	assert.NoError(t, job.Validate())
+}
+
+// TestJobOptions_PaperSizeRegistry tests registering named paper sizes
+func TestJobOptions_PaperSizeRegistry(t *testing.T) {
+	assert.True(t, render.IsValidPageSize(render.PageA4))
+	assert.True(t, render.IsValidPageSize(render.PageCustom))
+	assert.False(t, render.IsValidPageSize("test-envelope"))
+
+	assert.Error(t, render.RegisterPaperSize("", 4, 9))
+	assert.Error(t, render.RegisterPaperSize(render.PageCustom, 4, 9))
+	assert.ErrorIs(t, render.RegisterPaperSize("test-envelope", 0, 9), render.ErrInvalidPaperSize)
+
+	assert.NoError(t, render.RegisterPaperSize("test-envelope", 4.125, 9.5))
+	assert.True(t, render.IsValidPageSize("test-envelope"))
+	assert.Contains(t, render.PageSizeNames(), "test-envelope")
+	assert.Contains(t, render.ValidPageSizes, "test-envelope")
+
+	job := render.NewRenderJob(nil, uuid.New())
+	job.PageSize = "test-envelope"
+	opts := job.ToPDFOptions()
+	assert.Equal(t, 4.125, *opts.PaperWidth)
+	assert.Equal(t, 9.5, *opts.PaperHeight)
+}
+
+// TestJobOptions_Preset tests preset validation and application
+func TestJobOptions_Preset(t *testing.T) {
+	jsEvent := true
+	timeout := 10
+
+	assert.ErrorIs(t, render.RegisterPreset("test-bad-size", &render.Preset{PageSize: "A0"}), render.ErrInvalidPresetPageSize)
+	assert.ErrorIs(t, render.RegisterPreset("test-bad-margins", &render.Preset{Margins: "wide"}), render.ErrInvalidPresetMargins)
+	assert.ErrorIs(t, render.RegisterPreset("test-bad-length", &render.Preset{MarginTop: "1parsec"}), render.ErrInvalidLength)
+	assert.ErrorIs(t, render.RegisterPreset("test-bad-priority", &render.Preset{Priority: "urgent"}), render.ErrInvalidPresetPriority)
+
+	preset := &render.Preset{
+		PageSize:   render.PageLetter,
+		Margins:    render.MarginCustom,
+		MarginTop:  "10mm",
+		JsEvent:    &jsEvent,
+		JsTimeoutS: &timeout,
+		Priority:   render.PriorityLow,
+	}
+	assert.NoError(t, render.RegisterPreset("test-invoice", preset))
+	p, ok := render.GetPreset("test-invoice")
+	assert.True(t, ok)
+	assert.Contains(t, render.PresetNames(), "test-invoice")
+
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.NoError(t, p.Apply(job))
+	assert.Equal(t, render.PageLetter, job.PageSize)
+	assert.Equal(t, render.MarginCustom, job.MarginStyle)
+	assert.InDelta(t, 10/25.4, job.MarginTop, 0.0001)
+	assert.True(t, job.UseJSEvent)
+	assert.Equal(t, 10, job.JsTimeoutS)
+	assert.Equal(t, render.PriorityLow, job.Priority)
+	// unset values keep job defaults
+	assert.False(t, job.Landscape)
+	assert.Equal(t, render.DefaultClient, job.Client)
+	assert.Equal(t, render.JobDefaultSettlingTime, job.JobSettlingTimeMs)
+}
+
+// TestJobOptions_Screenshot tests screenshot options and content types
+func TestJobOptions_Screenshot(t *testing.T) {
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.False(t, job.IsImage())
+	assert.Equal(t, "application/pdf", job.ContentType())
+
+	job.OutputFormat = render.OutputPNG
+	assert.True(t, job.IsImage())
+	assert.Equal(t, "image/png", job.ContentType())
+	req := job.ToScreenshotOptions()
+	assert.Equal(t, proto.PageCaptureScreenshotFormatPng, req.Format)
+	assert.Nil(t, req.Quality)
+
+	job.OutputFormat = render.OutputJPEG
+	job.Quality = 75
+	req = job.ToScreenshotOptions()
+	assert.Equal(t, "image/jpeg", job.ContentType())
+	assert.Equal(t, proto.PageCaptureScreenshotFormatJpeg, req.Format)
+	assert.Equal(t, 75, *req.Quality)
+
+	job.OutputFormat = render.OutputWebP
+	job.ViewportWidth, job.ViewportHeight, job.DeviceScaleFactor = 800, 600, 2
+	req = job.ToScreenshotOptions()
+	assert.Equal(t, "image/webp", job.ContentType())
+	assert.Equal(t, proto.PageCaptureScreenshotFormatWebp, req.Format)
+	viewport := job.ToViewport()
+	assert.Equal(t, 800, viewport.Width)
+	assert.Equal(t, 600, viewport.Height)
+	assert.Equal(t, 2.0, viewport.DeviceScaleFactor)
+
+	// full-page captures are capped so the scaled image stays within JobMaxViewportSize
+	job.DeviceScaleFactor = 1
+	clip := job.ToCaptureClip(1280, 50000)
+	assert.Equal(t, 1280.0, clip.Width)
+	assert.Equal(t, float64(render.JobMaxViewportSize), clip.Height)
+	job.DeviceScaleFactor = 4
+	clip = job.ToCaptureClip(20000, 50000)
+	assert.Equal(t, float64(render.JobMaxViewportSize/4), clip.Width)
+	assert.Equal(t, float64(render.JobMaxViewportSize/4), clip.Height)
+	assert.LessOrEqual(t, clip.Height*job.DeviceScaleFactor, float64(render.JobMaxViewportSize))
+	job.DeviceScaleFactor = 3
+	clip = job.ToCaptureClip(1280, 50000)
+	assert.Equal(t, 1280.0, clip.Width)
+	assert.LessOrEqual(t, clip.Height*job.DeviceScaleFactor, float64(render.JobMaxViewportSize))
+}
+
+// TestJobOptions_Paginate tests paginated image output is returned as a zip archive
+func TestJobOptions_Paginate(t *testing.T) {
+	job := render.NewRenderJob(nil, uuid.New())
+	job.Paginate = true
+	// ignored for pdf output
+	assert.Equal(t, "application/pdf", job.ContentType())
+
+	job.OutputFormat = render.OutputPNG
+	assert.Equal(t, render.ContentTypeZip, job.ContentType())
+}
+
+// TestJobOptions_Snapshot tests snapshot output content types
+func TestJobOptions_Snapshot(t *testing.T) {
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.False(t, job.IsSnapshot())
+
+	job.OutputFormat = render.OutputMHTML
+	assert.True(t, job.IsSnapshot())
+	assert.False(t, job.IsImage())
+	assert.Equal(t, "multipart/related", job.ContentType())
+
+	job.OutputFormat = render.OutputHTML
+	assert.True(t, job.IsSnapshot())
+	assert.Equal(t, "text/html; charset=utf-8", job.ContentType())
+}
+
+// TestJobOptions_WaitFor tests readiness condition parsing
+func TestJobOptions_WaitFor(t *testing.T) {
+	valid := map[string]render.WaitCondition{
+		"console:charts-done":     {Type: render.WaitConsole, Value: "charts-done"},
+		"selector:#chart svg":     {Type: render.WaitSelector, Value: "#chart svg"},
+		"expr:window.a === 'b:c'": {Type: render.WaitExpression, Value: "window.a === 'b:c'"},
+		"promise":                 {Type: render.WaitPromise},
+		"fonts":                   {Type: render.WaitFonts},
+		"network_idle":            {Type: render.WaitNetworkIdle, Value: "500"},
+		"network_idle:250":        {Type: render.WaitNetworkIdle, Value: "250"},
+	}
+	for s, expected := range valid {
+		cond, err := render.ParseWaitCondition(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, cond, s)
+	}
+
+	for _, s := range []string{"", "unknown", "console", "selector: ", "expr:", "promise:x", "fonts:x", "network_idle:-1", "network_idle:abc", "network_idle:60000"} {
+		_, err := render.ParseWaitCondition(s)
+		assert.ErrorIs(t, err, render.ErrInvalidWaitCondition, s)
+	}
+
+	conditions, err := render.ParseWaitConditions([]string{"fonts", "selector:h1"})
+	assert.NoError(t, err)
+	assert.Len(t, conditions, 2)
+	_, err = render.ParseWaitConditions(make([]string, render.WaitMaxConditions+1))
+	assert.ErrorIs(t, err, render.ErrInvalidWaitCondition)
+
+	// js_event adds the default console message
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.Empty(t, job.ReadyConditions())
+	job.UseJSEvent = true
+	job.WaitFor = conditions
+	ready := job.ReadyConditions()
+	assert.Len(t, ready, 3)
+	assert.Equal(t, render.WaitCondition{Type: render.WaitConsole, Value: render.ReadyConsoleMessage}, ready[0])
+
+	// presets validate and apply conditions
+	assert.ErrorIs(t, render.RegisterPreset("test-bad-wait", &render.Preset{WaitFor: []string{"never"}}), render.ErrInvalidWaitCondition)
+	job = render.NewRenderJob(nil, uuid.New())
+	assert.NoError(t, (&render.Preset{WaitFor: []string{"fonts"}}).Apply(job))
+	assert.Equal(t, []render.WaitCondition{{Type: render.WaitFonts}}, job.WaitFor)
+}
+
+// TestJobOptions_StrictReady tests the server-wide js_event_strict default and preset override
+func TestJobOptions_StrictReady(t *testing.T) {
+	defer render.SetStrictReadyDefault(false)
+
+	assert.False(t, render.NewRenderJob(nil, uuid.New()).StrictReady)
+	render.SetStrictReadyDefault(true)
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.True(t, job.StrictReady)
+
+	strict := false
+	assert.NoError(t, (&render.Preset{JsEventStrict: &strict}).Apply(job))
+	assert.False(t, job.StrictReady)
+}
+
+// TestJobOptions_Diagnostics tests diagnostics collection limits and JSON format
+func TestJobOptions_Diagnostics(t *testing.T) {
+	diag := render.NewDiagnostics()
+	data, err := json.Marshal(diag)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"console":[],"exceptions":[],"log":[],"notFound":[],"truncated":false}`, string(data))
+
+	diag.AddConsole("log", []string{"hello", "world"})
+	diag.AddException(&proto.RuntimeExceptionDetails{Text: "Uncaught", URL: "http://localhost/index.html", LineNumber: 3})
+	diag.AddLog(&proto.LogLogEntry{Level: proto.LogLogEntryLevelError, Source: proto.LogLogEntrySourceNetwork, Text: "failed"})
+	diag.AddNotFound("missing.css")
+	assert.Equal(t, 4, diag.Count())
+	assert.Equal(t, "hello world", diag.Console[0].Message)
+	assert.Equal(t, "Uncaught", diag.Exceptions[0].Message)
+	assert.Equal(t, "error", diag.Log[0].Level)
+	assert.False(t, diag.Truncated)
+
+	for i := 0; i < render.DiagnosticsMaxEntries+10; i++ {
+		diag.AddNotFound(strings.Repeat("x", render.DiagnosticsMaxMessage+1))
+	}
+	assert.Len(t, diag.NotFound, render.DiagnosticsMaxEntries)
+	assert.Len(t, diag.NotFound[1], render.DiagnosticsMaxMessage)
+	assert.True(t, diag.Truncated)
+}
+
+// TestJobOptions_Data tests per-request data validation
+func TestJobOptions_Data(t *testing.T) {
+	job := render.NewRenderJob(nil, uuid.New())
+	assert.NoError(t, job.SetData([]byte(`{"customer": {"name": "ACME"}, "items": [1, 2, 3]}`)))
+	assert.NotEmpty(t, job.Data)
+
+	job = render.NewRenderJob(nil, uuid.New())
+	assert.ErrorIs(t, job.SetData([]byte(`{"customer": `)), render.ErrInvalidData)
+	assert.ErrorIs(t, job.SetData([]byte(``)), render.ErrInvalidData)
+	assert.ErrorIs(t, job.SetData(make([]byte, render.MaxDataSize+1)), render.ErrDataTooLarge)
+	assert.Nil(t, job.Data)
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+	"zipreport-server/pkg/render"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestQueue_Limits tests the render queue depth and max wait
+func TestQueue_Limits(t *testing.T) {
+	q := render.NewQueue(1, sharedMetrics)
+	q.SetLimits(1, 50*time.Millisecond)
+	rejected := testutil.ToFloat64(sharedMetrics.QueueRejected)
+
+	// first job takes the only slot
+	running, err := q.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, running.Wait(context.Background()))
+	assert.Equal(t, 0, q.Depth())
+
+	// second job waits, third job is rejected
+	waiting, err := q.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	assert.Equal(t, 1, q.Depth())
+	assert.Equal(t, 1.0, testutil.ToFloat64(sharedMetrics.QueueDepth))
+
+	_, err = q.Reserve(render.DefaultClient)
+	var queueErr *render.QueueError
+	require.ErrorAs(t, err, &queueErr)
+	assert.ErrorIs(t, err, render.ErrQueueFull)
+	assert.GreaterOrEqual(t, queueErr.RetryAfter, 1)
+	assert.LessOrEqual(t, queueErr.RetryAfter, render.MaxRetryAfter)
+
+	// background jobs ignore the depth only if forced
+	_, err = q.ReserveBackground(render.DefaultClient, false)
+	assert.ErrorIs(t, err, render.ErrQueueFull)
+	forced, err := q.ReserveBackground(render.DefaultClient, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, q.Depth())
+	forced.Release()
+	assert.Equal(t, 1, q.Depth())
+
+	// waiting jobs time out while the slot is busy
+	err = waiting.Wait(context.Background())
+	assert.ErrorIs(t, err, render.ErrQueueTimeout)
+	assert.Equal(t, 0, q.Depth())
+	assert.Equal(t, rejected+3, testutil.ToFloat64(sharedMetrics.QueueRejected))
+	waiting.Release()
+	assert.ErrorIs(t, waiting.Wait(context.Background()), render.ErrTicketReleased)
+
+	// canceled waits leave the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled, err := q.ReserveBackground(render.DefaultClient, false)
+	require.NoError(t, err)
+	cancel()
+	assert.True(t, errors.Is(canceled.Wait(ctx), context.Canceled))
+	assert.Equal(t, 0, q.Depth())
+
+	// released slots are available to the next job; Release can be called more than once
+	running.Release()
+	running.Release()
+	next, err := q.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, next.Wait(context.Background()))
+	next.Release()
+}
+
+// TestQueue_Priority tests jobs are started by priority class, and in weighted round robin between clients
+func TestQueue_Priority(t *testing.T) {
+	q := render.NewQueue(1, sharedMetrics)
+	q.SetClientWeight("batch", 2)
+
+	// hold the only slot
+	running, err := q.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, running.Wait(context.Background()))
+
+	started := make(chan string, 16)
+	enqueue := func(name, priority, client string) {
+		ticket, err := q.ReserveBackground(client, false)
+		require.NoError(t, err)
+		ticket.Assign(priority)
+		class := priority
+		if !slices.Contains(render.ValidPriority, priority) {
+			class = render.PriorityNormal
+		}
+		depth := testutil.ToFloat64(sharedMetrics.PriorityQueueDepth.WithLabelValues(class))
+		go func() {
+			if ticket.Wait(context.Background()) == nil {
+				started <- name
+				ticket.Release()
+			}
+		}()
+		// wait until the ticket is queued, so the order is deterministic
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(sharedMetrics.PriorityQueueDepth.WithLabelValues(class)) == depth+1
+		}, time.Second, time.Millisecond)
+	}
+
+	enqueue("low-1", render.PriorityLow, "batch")
+	enqueue("batch-1", render.PriorityNormal, "batch")
+	enqueue("batch-2", render.PriorityNormal, "batch")
+	enqueue("batch-3", render.PriorityNormal, "batch")
+	enqueue("batch-4", render.PriorityNormal, "batch")
+	enqueue("web-1", render.PriorityNormal, "web")
+	enqueue("web-2", render.PriorityNormal, "web")
+	enqueue("high-1", render.PriorityHigh, "batch")
+	enqueue("unknown-1", "urgent", "") // unknown priorities are normal, for the default client
+	assert.Equal(t, 9, q.Depth())
+
+	running.Release()
+	order := make([]string, 0, 9)
+	for i := 0; i < 9; i++ {
+		select {
+		case name := <-started:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("jobs not started, order so far: %v", order)
+		}
+	}
+	assert.Equal(t, []string{
+		"high-1",
+		"batch-1", "batch-2", "web-1", "unknown-1", "batch-3", "batch-4", "web-2",
+		"low-1",
+	}, order)
+	assert.Equal(t, 0, q.Depth())
+}
+
+// TestQueue_ClientLimits tests the per-client queue depth and priority clamping
+func TestQueue_ClientLimits(t *testing.T) {
+	q := render.NewQueue(1, sharedMetrics)
+	q.SetLimits(10, 0)
+	q.SetClientMaxDepth("batch", 2)
+
+	// hold the only slot
+	running, err := q.Reserve(render.DefaultClient)
+	require.NoError(t, err)
+	require.NoError(t, running.Wait(context.Background()))
+
+	first, err := q.ReserveBackground("batch", false)
+	require.NoError(t, err)
+	second, err := q.Reserve("batch")
+	require.NoError(t, err)
+	_, err = q.Reserve("batch")
+	var queueErr *render.QueueError
+	require.ErrorAs(t, err, &queueErr)
+	assert.ErrorIs(t, err, render.ErrClientQueueFull)
+	assert.ErrorIs(t, err, render.ErrQueueFull)
+	assert.Equal(t, render.CodeQueueFull, render.ErrorCode(err))
+
+	// other clients are only bounded by the queue depth, and forced jobs ignore the client depth
+	other, err := q.Reserve("web")
+	require.NoError(t, err)
+	forced, err := q.ReserveBackground("batch", true)
+	require.NoError(t, err)
+	assert.Equal(t, 4, q.Depth())
+
+	// released places are available to the client again
+	first.Release()
+	forced.Release()
+	third, err := q.Reserve("batch")
+	require.NoError(t, err)
+	for _, ticket := range []*render.Ticket{second, third, other, running} {
+		ticket.Release()
+	}
+	assert.Equal(t, 0, q.Depth())
+
+	// priorities are lowered to the client maximum
+	assert.Equal(t, render.PriorityNormal, render.ClampPriority(render.PriorityHigh, render.PriorityNormal))
+	assert.Equal(t, render.PriorityLow, render.ClampPriority(render.PriorityLow, render.PriorityNormal))
+	assert.Equal(t, render.PriorityLow, render.ClampPriority(render.PriorityHigh, render.PriorityLow))
+	assert.Equal(t, render.PriorityHigh, render.ClampPriority(render.PriorityHigh, ""))
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"zipreport-server/pkg/registry"
+
+	"github.com/oddbit-project/blueprint/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegistry_Templates tests template upload, versioning, deletion and reload
+func TestRegistry_Templates(t *testing.T) {
+	logConfig := log.NewDefaultConfig()
+	logConfig.Level = "error"
+	require.NoError(t, log.Configure(logConfig))
+	logger := log.New("test-registry")
+
+	dir := t.TempDir()
+	reg, err := registry.NewRegistry(dir, logger)
+	require.NoError(t, err)
+	defer reg.Close()
+
+	zpt, err := os.ReadFile(filepath.Join("fixtures", "test.zpt"))
+	require.NoError(t, err)
+
+	// invalid zpt files are rejected
+	_, err = reg.Create("invalid", bytes.NewReader([]byte("not a zip file")))
+	assert.Error(t, err)
+	assert.Empty(t, reg.List())
+
+	tpl, err := reg.Create("invoice", bytes.NewReader(zpt))
+	require.NoError(t, err)
+	assert.Equal(t, "invoice", tpl.Name)
+	require.Len(t, tpl.Versions, 1)
+	assert.Equal(t, 1, tpl.Versions[0].Version)
+	assert.Equal(t, int64(len(zpt)), tpl.Versions[0].Size)
+	assert.Len(t, tpl.Versions[0].Sha256, 64)
+
+	tpl, err = reg.AddVersion(tpl.Id, bytes.NewReader(zpt))
+	require.NoError(t, err)
+	require.Len(t, tpl.Versions, 2)
+	assert.Equal(t, 2, tpl.Versions[1].Version)
+
+	_, err = reg.AddVersion("00000000-0000-0000-0000-000000000000", bytes.NewReader(zpt))
+	assert.ErrorIs(t, err, registry.ErrNotFound)
+
+	// acquire latest and specific versions
+	reader, release, err := reg.Acquire(tpl.Id, 0)
+	require.NoError(t, err)
+	buf, err := reader.ReadFile("test.html")
+	require.NoError(t, err)
+	assert.NotEmpty(t, buf)
+	_, _, err = reg.Acquire(tpl.Id, 3)
+	assert.ErrorIs(t, err, registry.ErrNotFound)
+
+	// templates are reloaded from disk
+	reg2, err := registry.NewRegistry(dir, logger)
+	require.NoError(t, err)
+	reloaded, err := reg2.Get(tpl.Id)
+	require.NoError(t, err)
+	assert.Equal(t, tpl, reloaded)
+	reg2.Close()
+
+	// deleted versions remain readable until released
+	require.NoError(t, reg.DeleteVersion(tpl.Id, 2))
+	buf, err = reader.ReadFile("test.html")
+	require.NoError(t, err)
+	assert.NotEmpty(t, buf)
+	release()
+	tpl, err = reg.Get(tpl.Id)
+	require.NoError(t, err)
+	require.Len(t, tpl.Versions, 1)
+	assert.NoFileExists(t, filepath.Join(dir, tpl.Id, "2.zpt"))
+
+	// numbers of deleted versions are not reused, including after a reload
+	tpl, err = reg.AddVersion(tpl.Id, bytes.NewReader(zpt))
+	require.NoError(t, err)
+	require.Len(t, tpl.Versions, 2)
+	assert.Equal(t, 3, tpl.Versions[1].Version)
+	require.NoError(t, reg.DeleteVersion(tpl.Id, 3))
+	reg2, err = registry.NewRegistry(dir, logger)
+	require.NoError(t, err)
+	reloaded, err = reg2.AddVersion(tpl.Id, bytes.NewReader(zpt))
+	require.NoError(t, err)
+	assert.Equal(t, 4, reloaded.Versions[1].Version)
+	require.NoError(t, reg2.DeleteVersion(tpl.Id, 4))
+	reg2.Close()
+
+	// deleting the last version deletes the template
+	require.NoError(t, reg.DeleteVersion(tpl.Id, 1))
+	_, err = reg.Get(tpl.Id)
+	assert.ErrorIs(t, err, registry.ErrNotFound)
+	assert.NoDirExists(t, filepath.Join(dir, tpl.Id))
+	assert.ErrorIs(t, reg.Delete(tpl.Id), registry.ErrNotFound)
+}
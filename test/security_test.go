@@ -103,6 +103,30 @@ func TestZptServer_PathTraversal(t *testing.T) {
 	}
 }
 
+// TestZptServer_Data tests the per-request data is served at /_zpt/data.json
+func TestZptServer_Data(t *testing.T) {
+	logConfig := log.NewDefaultConfig()
+	logConfig.Level = "error"
+	require.NoError(t, log.Configure(logConfig))
+	logger := log.New("test-security")
+
+	reader, err := zpt.NewZptReaderFromFile("fixtures/test.zpt")
+	require.NoError(t, err)
+	server := zpt.NewZptServer(reader, 43000, logger)
+
+	// no data
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/_zpt/data.json", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	server.SetData([]byte(`{"name":"test"}`))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest("GET", "/_zpt/data.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"name":"test"}`, w.Body.String())
+}
+
 // TestZptReader_LargeFileProtection tests protection against zip-bomb entries
 func TestZptReader_LargeFileProtection(t *testing.T) {
 	reader, err := zpt.NewZptReaderFromFile("fixtures/test.zpt")
@@ -170,7 +194,7 @@ func TestRenderTimeout(t *testing.T) {
 	srv.Router.ServeHTTP(w, req)
 
 	// Should either succeed quickly or timeout
-	assert.Contains(t, []int{http.StatusOK, http.StatusInternalServerError}, w.Code)
+	assert.Contains(t, []int{http.StatusOK, http.StatusGatewayTimeout}, w.Code)
 	_ = ctx
 }
 
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"zipreport-server/pkg/zpt"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildZipFiles returns an in-memory zip archive with the given entries
+func buildZipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// TestZptReader_Validate tests the pre-flight check of report archives
+func TestZptReader_Validate(t *testing.T) {
+	files := map[string]string{
+		"report.html": `<html><head>
+			<link rel="stylesheet" href="css/style.css">
+			<link rel="stylesheet" href="https://cdn.example.com/lib.css">
+			<script src='js/app.js?v=2'></script>
+			<script src="//cdn.example.com/lib.js"></script>
+			</head><body style="background: url(img/bg.png)">
+			<img src=img/logo.png><img src="img/missing.png"><img src="data:image/png;base64,AAAA">
+			<a href="#top">top</a><a href="mailto:info@example.com">mail</a><a href="{{page}}">page</a>
+			<script>fetch("/_zpt/data.json")</script><img src="/_zpt/data.json">
+			</body></html>`,
+		"css/style.css": `@font-face { src: url("../fonts/font.woff2") } .a { background: url('/img/logo.png') }
+			.b { background: url(missing.svg) } .c { background: url(https://cdn.example.com/bg.png) }`,
+		"js/app.js":          `console.log("ready")`,
+		"img/bg.png":         "png",
+		"img/logo.png":       "png",
+		"fonts/font.woff2":   "font",
+		"pages/summary.html": `<img src="../img/logo.png"><a href="../report.html">back</a>`,
+	}
+	archive := buildZipFiles(t, files)
+	reader, err := zpt.NewZptReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+
+	report := reader.Validate("")
+	assert.Equal(t, zpt.DefaultScriptName, report.IndexFile)
+	assert.True(t, report.IndexFound)
+	assert.False(t, report.Valid, "missing assets should fail validation")
+	assert.Equal(t, len(files), report.Entries)
+	var size uint64
+	for _, content := range files {
+		size += uint64(len(content))
+	}
+	assert.Equal(t, size, report.Size)
+	assert.Equal(t, []string{"pages/summary.html", "report.html"}, report.HtmlFiles)
+	assert.ElementsMatch(t, []zpt.AssetRef{
+		{File: "report.html", Url: "img/missing.png"},
+		{File: "css/style.css", Url: "missing.svg"},
+	}, report.MissingAssets)
+	assert.ElementsMatch(t, []zpt.AssetRef{
+		{File: "report.html", Url: "https://cdn.example.com/lib.css"},
+		{File: "report.html", Url: "//cdn.example.com/lib.js"},
+		{File: "css/style.css", Url: "https://cdn.example.com/bg.png"},
+	}, report.ExternalUrls)
+
+	// missing index file
+	report = reader.Validate("index.html")
+	assert.False(t, report.IndexFound)
+	assert.False(t, report.Valid)
+
+	// a complete archive is valid
+	archive = buildZipFiles(t, map[string]string{
+		"index.html": `<link href="style.css" rel="stylesheet"><img src="logo.png">`,
+		"style.css":  `body { color: black }`,
+		"logo.png":   "png",
+	})
+	reader, err = zpt.NewZptReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+	report = reader.Validate("index.html?page=1")
+	assert.True(t, report.IndexFound)
+	assert.True(t, report.Valid)
+	assert.Empty(t, report.MissingAssets)
+	assert.Empty(t, report.ExternalUrls)
+}
+
+// TestValidateEndpoint tests the /v2/validate endpoint
+func TestValidateEndpoint(t *testing.T) {
+	srv, engine, ctx, cancel := setupTestServer(t)
+	defer cancel()
+	defer engine.Shutdown()
+
+	validate := func(zipPath string, fields map[string]string) *httptest.ResponseRecorder {
+		req := createMultipartRequest(t, zipPath, fields)
+		req.URL.Path = "/v2/validate"
+		req.Header.Set("X-Auth-Key", testAuthToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := validate("fixtures/multi-resource.zpt", map[string]string{"script": "index.html"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var report zpt.ValidationReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.Valid)
+	assert.True(t, report.IndexFound)
+	assert.Equal(t, 3, report.Entries)
+	assert.Empty(t, report.MissingAssets)
+
+	w = validate("fixtures/missing-index.zpt", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.Valid)
+	assert.False(t, report.IndexFound)
+	assert.Equal(t, []string{"other.html"}, report.HtmlFiles)
+
+	w = validate("fixtures/corrupt.zpt", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_archive")
+	_ = ctx
+}
+
+// TestZptReader_ResolveIndex tests the index file lookup and fallback
+func TestZptReader_ResolveIndex(t *testing.T) {
+	t.Cleanup(func() { zpt.SetIndexFallback(false) })
+	newReader := func(files map[string]string) *zpt.ZptReader {
+		archive := buildZipFiles(t, files)
+		reader, err := zpt.NewZptReader(bytes.NewReader(archive), int64(len(archive)))
+		require.NoError(t, err)
+		return reader
+	}
+
+	// explicit and default index files
+	reader := newReader(map[string]string{"report.html": "", "other.html": "", "pages/a.html": ""})
+	index, err := reader.ResolveIndex("")
+	require.NoError(t, err)
+	assert.Equal(t, zpt.DefaultScriptName, index)
+	index, err = reader.ResolveIndex("other.html?page=1")
+	require.NoError(t, err)
+	assert.Equal(t, "other.html?page=1", index)
+	_, err = reader.ResolveIndex("missing.html")
+	var indexErr *zpt.IndexError
+	require.ErrorAs(t, err, &indexErr)
+	assert.ErrorIs(t, err, zpt.ErrIndexNotFound)
+	assert.Equal(t, "missing.html", indexErr.IndexFile)
+	assert.Equal(t, []string{"other.html", "pages/a.html", "report.html"}, indexErr.HtmlFiles)
+
+	// no fallback by default
+	single := newReader(map[string]string{"summary.html": "", "_header.html": "", "pages/a.html": ""})
+	_, err = single.ResolveIndex("")
+	require.ErrorAs(t, err, &indexErr)
+	assert.Equal(t, zpt.DefaultScriptName, indexErr.IndexFile)
+
+	zpt.SetIndexFallback(true)
+	index, err = single.ResolveIndex("")
+	require.NoError(t, err)
+	assert.Equal(t, "summary.html", index, "the only top-level page, excluding header and footer templates")
+
+	index, err = newReader(map[string]string{"index.html": "", "other.html": ""}).ResolveIndex("")
+	require.NoError(t, err)
+	assert.Equal(t, "index.html", index)
+
+	// ambiguous top-level pages
+	_, err = newReader(map[string]string{"a.html": "", "b.html": ""}).ResolveIndex("")
+	require.ErrorAs(t, err, &indexErr)
+	assert.Equal(t, []string{"a.html", "b.html"}, indexErr.HtmlFiles)
+
+	// explicit index files never fall back
+	_, err = single.ResolveIndex("report.html")
+	assert.ErrorIs(t, err, zpt.ErrIndexNotFound)
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"zipreport-server/pkg/jobs"
+	"zipreport-server/pkg/render"
+	"zipreport-server/pkg/webhook"
+
+	"github.com/google/uuid"
+	"github.com/oddbit-project/blueprint/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebhook_Delivery tests signed callback delivery, retries and failures
+func TestWebhook_Delivery(t *testing.T) {
+	logConfig := log.NewDefaultConfig()
+	logConfig.Level = "error"
+	require.NoError(t, log.Configure(logConfig))
+	logger := log.New("test-webhook")
+
+	for _, u := range []string{"", "ftp://example.com/", "/relative", "http://", "not a url"} {
+		assert.ErrorIs(t, webhook.ValidateURL(u), webhook.ErrInvalidURL, u)
+	}
+	assert.NoError(t, webhook.ValidateURL("https://example.com/callback?token=1"))
+
+	cfg := webhook.NewConfig()
+	assert.False(t, cfg.Enabled())
+	cfg.Secret = "secret"
+	cfg.MaxAttempts = 3
+	cfg.InitialBackoffMs = 1
+	cfg.MaxBackoffMs = 5
+	cfg.AllowedHosts = []string{"127.0.0.1"} // the httptest server
+	require.NoError(t, cfg.Validate())
+
+	var calls atomic.Int32
+	var failUntil atomic.Int32
+	bodies := make(chan []byte, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) <= failUntil.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		assert.Equal(t, webhook.Sign("secret", r.Header.Get(webhook.HeaderTimestamp), body), r.Header.Get(webhook.HeaderSignature))
+		assert.NotEmpty(t, r.Header.Get(webhook.HeaderJobId))
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	d := webhook.NewDispatcher(context.Background(), cfg, sharedMetrics, logger)
+	job := render.NewRenderJob(nil, uuid.New())
+	attempts := testutil.ToFloat64(sharedMetrics.WebhookAttempts)
+	failures := testutil.ToFloat64(sharedMetrics.WebhookFailures)
+
+	// successful job, delivered on the third attempt
+	failUntil.Store(2)
+	d.Callback(srv.URL, job)(&jobs.Info{Id: job.Id.String(), Status: jobs.StatusDone}, &render.JobResult{Success: true, Output: []byte("%PDF-1.4")})
+	d.Wait()
+	assert.Equal(t, []byte("%PDF-1.4"), <-bodies)
+	assert.Equal(t, attempts+3, testutil.ToFloat64(sharedMetrics.WebhookAttempts))
+	assert.Equal(t, failures, testutil.ToFloat64(sharedMetrics.WebhookFailures))
+
+	// failed job, delivered as JSON
+	calls.Store(0)
+	failUntil.Store(0)
+	tplErr := &render.TemplateError{Code: "no_data", Message: "missing data"}
+	d.Callback(srv.URL, job)(&jobs.Info{Id: job.Id.String(), Status: jobs.StatusFailed}, &render.JobResult{Error: tplErr})
+	d.Wait()
+	var failed map[string]any
+	require.NoError(t, json.Unmarshal(<-bodies, &failed))
+	assert.Equal(t, "failed", failed["status"])
+	assert.Equal(t, "no_data", failed["code"])
+	assert.Equal(t, "missing data", failed["error"])
+	assert.Equal(t, false, failed["retryable"])
+
+	// undeliverable callbacks give up after maxAttempts
+	calls.Store(0)
+	failUntil.Store(10)
+	d.Callback(srv.URL, job)(&jobs.Info{Id: job.Id.String(), Status: jobs.StatusFailed}, &render.JobResult{Error: errors.New("boom")})
+	d.Wait()
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, failures+1, testutil.ToFloat64(sharedMetrics.WebhookFailures))
+}
+
+// TestWebhook_ForbiddenAddresses tests that callbacks can't reach internal addresses, or follow redirects
+func TestWebhook_ForbiddenAddresses(t *testing.T) {
+	logger := log.New("test-webhook")
+	cfg := webhook.NewConfig()
+	cfg.Secret = "secret"
+	cfg.MaxAttempts = 1
+	d := webhook.NewDispatcher(context.Background(), cfg, sharedMetrics, logger)
+
+	for _, u := range []string{
+		"http://127.0.0.1:42000/",
+		"http://localhost:42000/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/callback",
+		"http://192.168.1.10/callback",
+		"http://[::1]/callback",
+		"http://0.0.0.0/callback",
+	} {
+		assert.ErrorIs(t, d.ValidateURL(u), webhook.ErrInvalidURL, u)
+	}
+	assert.NoError(t, d.ValidateURL("https://example.com/callback"))
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+	job := render.NewRenderJob(nil, uuid.New())
+	info := &jobs.Info{Id: job.Id.String(), Status: jobs.StatusDone}
+	result := &render.JobResult{Success: true, Output: []byte("%PDF-1.4")}
+
+	// names resolving to loopback addresses are rejected when dialing
+	failures := testutil.ToFloat64(sharedMetrics.WebhookFailures)
+	d.Callback(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), job)(info, result)
+	d.Wait()
+	assert.Equal(t, int32(0), calls.Load())
+	assert.Equal(t, failures+1, testutil.ToFloat64(sharedMetrics.WebhookFailures))
+
+	// allowlisted hosts are delivered, but redirects are not followed
+	cfg.AllowedHosts = []string{"127.0.0.1"}
+	d = webhook.NewDispatcher(context.Background(), cfg, sharedMetrics, logger)
+	assert.NoError(t, d.ValidateURL(srv.URL))
+	d.Callback(srv.URL, job)(info, result)
+	d.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, failures+2, testutil.ToFloat64(sharedMetrics.WebhookFailures))
+}